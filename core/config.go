@@ -31,3 +31,11 @@ func (c *Config) GetInt(key string) int {
 func (c *Config) GetDuration(key string) time.Duration {
 	return viper.GetDuration(key)
 }
+
+func (c *Config) GetBool(key string) bool {
+	return viper.GetBool(key)
+}
+
+func (c *Config) GetStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
@@ -0,0 +1,61 @@
+package block
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/block"
+)
+
+type Ctrl struct {
+	blockService *block.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		blockService: block.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) Block(ctx *fiber.Ctx) error {
+	req := &proto.BlockReq{}
+	if err := ctx.BodyParser(req); err != nil || req.UID == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Block User: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.blockService.Block(ctx.Context(), uid, req); err != nil {
+		if errors.Is(err, block.ErrInvalidTarget) {
+			return response.Error(ctx, fiber.StatusBadRequest, "Block User: "+err.Error())
+		}
+		log.Errorf("block user error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Block User: Internal Server Error")
+	}
+	return response.Success(ctx, "Block User Success")
+}
+
+func (ctrl *Ctrl) Unblock(ctx *fiber.Ctx) error {
+	req := &proto.BlockReq{}
+	if err := ctx.BodyParser(req); err != nil || req.UID == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Unblock User: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.blockService.Unblock(ctx.Context(), uid, req); err != nil {
+		log.Errorf("unblock user error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Unblock User: Internal Server Error")
+	}
+	return response.Success(ctx, "Unblock User Success")
+}
+
+func (ctrl *Ctrl) List(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.blockService.List(ctx.Context(), uid)
+	if err != nil {
+		log.Errorf("list blocked users error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "List Blocked Users: Internal Server Error")
+	}
+	return response.Success(ctx, resp)
+}
@@ -0,0 +1,45 @@
+package broadcast
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+)
+
+type Ctrl struct {
+	connService *connections.WebSocketConnections
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		connService: connections.Default(),
+	}
+}
+
+func (ctrl *Ctrl) Send(ctx *fiber.Ctx) error {
+	req := &proto.BroadcastReq{}
+	if err := ctx.BodyParser(req); err != nil || req.Content == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Broadcast: Bad Request")
+	}
+	status, err := ctrl.connService.Broadcast(req.Content, nil)
+	if err != nil {
+		log.Errorf("broadcast error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Broadcast: Internal Server Error")
+	}
+	return response.Success(ctx, status)
+}
+
+// Status 查询广播进度 包含失败的用户数
+func (ctrl *Ctrl) Status(ctx *fiber.Ctx) error {
+	req := &proto.BroadcastStatusReq{}
+	if err := ctx.BodyParser(req); err != nil || req.Id == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Broadcast Status: Bad Request")
+	}
+	status := ctrl.connService.BroadcastStatusOf(req.Id)
+	if status == nil {
+		return response.Error(ctx, fiber.StatusNotFound, "Broadcast Status: Not Found")
+	}
+	return response.Success(ctx, status)
+}
@@ -0,0 +1,27 @@
+package discovery
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/service/discovery"
+)
+
+type Ctrl struct {
+	discoveryService *discovery.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		discoveryService: discovery.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) Get(ctx *fiber.Ctx) error {
+	resp, err := ctrl.discoveryService.Get()
+	if err != nil {
+		log.Errorf("get discovery document error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Get Discovery: Internal Server Error")
+	}
+	return response.Success(ctx, resp)
+}
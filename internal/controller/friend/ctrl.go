@@ -0,0 +1,100 @@
+package friend
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/friend"
+)
+
+type Ctrl struct {
+	friendService *friend.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		friendService: friend.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) SendRequest(ctx *fiber.Ctx) error {
+	req := &proto.FriendRequestSendReq{}
+	if err := ctx.BodyParser(req); err != nil || req.UID == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Send Friend Request: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.friendService.SendRequest(ctx.Context(), uid, req)
+	if err != nil {
+		return friendError(ctx, "Send Friend Request", err)
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) AcceptRequest(ctx *fiber.Ctx) error {
+	req := &proto.FriendRequestHandleReq{}
+	if err := ctx.BodyParser(req); err != nil || req.RequestId == 0 {
+		return response.Error(ctx, fiber.StatusBadRequest, "Accept Friend Request: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.friendService.Accept(ctx.Context(), uid, req); err != nil {
+		return friendError(ctx, "Accept Friend Request", err)
+	}
+	return response.Success(ctx, "Accept Friend Request Success")
+}
+
+func (ctrl *Ctrl) DeclineRequest(ctx *fiber.Ctx) error {
+	req := &proto.FriendRequestHandleReq{}
+	if err := ctx.BodyParser(req); err != nil || req.RequestId == 0 {
+		return response.Error(ctx, fiber.StatusBadRequest, "Decline Friend Request: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.friendService.Decline(ctx.Context(), uid, req); err != nil {
+		return friendError(ctx, "Decline Friend Request", err)
+	}
+	return response.Success(ctx, "Decline Friend Request Success")
+}
+
+func (ctrl *Ctrl) ListRequests(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.friendService.ListRequests(ctx.Context(), uid)
+	if err != nil {
+		return friendError(ctx, "List Friend Requests", err)
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) List(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.friendService.ListFriends(ctx.Context(), uid)
+	if err != nil {
+		return friendError(ctx, "List Friends", err)
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) Remove(ctx *fiber.Ctx) error {
+	req := &proto.FriendRemoveReq{}
+	if err := ctx.BodyParser(req); err != nil || req.UID == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Remove Friend: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.friendService.Remove(ctx.Context(), uid, req); err != nil {
+		return friendError(ctx, "Remove Friend", err)
+	}
+	return response.Success(ctx, "Remove Friend Success")
+}
+
+func friendError(ctx *fiber.Ctx, action string, err error) error {
+	switch {
+	case errors.Is(err, friend.ErrRequestNotFound):
+		return response.Error(ctx, fiber.StatusNotFound, action+": "+err.Error())
+	case errors.Is(err, friend.ErrInvalidTarget), errors.Is(err, friend.ErrAlreadyFriends):
+		return response.Error(ctx, fiber.StatusBadRequest, action+": "+err.Error())
+	}
+	log.Errorf("%s error: %s", action, err)
+	return response.Error(ctx, fiber.StatusInternalServerError, action+": Internal Server Error")
+}
@@ -0,0 +1,83 @@
+package group
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/group"
+)
+
+type Ctrl struct {
+	groupService *group.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		groupService: group.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) Create(ctx *fiber.Ctx) error {
+	req := &proto.GroupCreateReq{}
+	if err := ctx.BodyParser(req); err != nil || req.Name == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Create Group: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.groupService.Create(ctx.Context(), uid, req)
+	if err != nil {
+		log.Errorf("create group error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Create Group: Internal Server Error")
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) Rename(ctx *fiber.Ctx) error {
+	req := &proto.GroupRenameReq{}
+	if err := ctx.BodyParser(req); err != nil || req.GroupId == "" || req.Name == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Rename Group: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.groupService.Rename(ctx.Context(), uid, req); err != nil {
+		return groupError(ctx, "Rename Group", err)
+	}
+	return response.Success(ctx, "Rename Group Success")
+}
+
+func (ctrl *Ctrl) AddMembers(ctx *fiber.Ctx) error {
+	req := &proto.GroupMembersReq{}
+	if err := ctx.BodyParser(req); err != nil || req.GroupId == "" || len(req.Members) == 0 {
+		return response.Error(ctx, fiber.StatusBadRequest, "Add Group Members: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.groupService.AddMembers(ctx.Context(), uid, req); err != nil {
+		return groupError(ctx, "Add Group Members", err)
+	}
+	return response.Success(ctx, "Add Group Members Success")
+}
+
+func (ctrl *Ctrl) RemoveMembers(ctx *fiber.Ctx) error {
+	req := &proto.GroupMembersReq{}
+	if err := ctx.BodyParser(req); err != nil || req.GroupId == "" || len(req.Members) == 0 {
+		return response.Error(ctx, fiber.StatusBadRequest, "Remove Group Members: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.groupService.RemoveMembers(ctx.Context(), uid, req); err != nil {
+		return groupError(ctx, "Remove Group Members", err)
+	}
+	return response.Success(ctx, "Remove Group Members Success")
+}
+
+func groupError(ctx *fiber.Ctx, action string, err error) error {
+	switch {
+	case errors.Is(err, group.ErrGroupNotFound):
+		return response.Error(ctx, fiber.StatusNotFound, action+": Group Not Found")
+	case errors.Is(err, group.ErrPermissionDenied), errors.Is(err, group.ErrRemoveOwner):
+		return response.Error(ctx, fiber.StatusForbidden, action+": "+err.Error())
+	}
+	log.Errorf("%s error: %s", action, err)
+	return response.Error(ctx, fiber.StatusInternalServerError, action+": Internal Server Error")
+}
@@ -0,0 +1,76 @@
+package history
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/history"
+)
+
+type Ctrl struct {
+	historyService *history.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		historyService: history.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) List(ctx *fiber.Ctx) error {
+	req := &proto.HistoryListReq{}
+	if err := ctx.BodyParser(req); err != nil || req.ConversationId == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "List History: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.historyService.List(ctx.Context(), uid, req)
+	if err != nil {
+		return historyError(ctx, "List History", err)
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) GetSettings(ctx *fiber.Ctx) error {
+	req := &proto.ConversationSettingsGetReq{}
+	if err := ctx.BodyParser(req); err != nil || req.ConversationId == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Get Conversation Settings: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.historyService.GetSettings(ctx.Context(), uid, req.ConversationId)
+	if err != nil {
+		return historyError(ctx, "Get Conversation Settings", err)
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) UpdateSettings(ctx *fiber.Ctx) error {
+	req := &proto.ConversationSettingsUpdateReq{}
+	if err := ctx.BodyParser(req); err != nil || req.ConversationId == "" || negative(req.RetentionSeconds) ||
+		negative(req.ExpireSeconds) || negative(req.ExpireAfterReadSeconds) {
+		return response.Error(ctx, fiber.StatusBadRequest, "Update Conversation Settings: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.historyService.UpdateSettings(ctx.Context(), uid, req); err != nil {
+		return historyError(ctx, "Update Conversation Settings", err)
+	}
+	return response.Success(ctx, "Update Conversation Settings Success")
+}
+
+func historyError(ctx *fiber.Ctx, action string, err error) error {
+	switch {
+	case errors.Is(err, history.ErrInvalidConversation), errors.Is(err, history.ErrCursorNotFound):
+		return response.Error(ctx, fiber.StatusBadRequest, action+": "+err.Error())
+	case errors.Is(err, history.ErrPermissionDenied):
+		return response.Error(ctx, fiber.StatusForbidden, action+": "+err.Error())
+	}
+	log.Errorf("%s error: %s", action, err)
+	return response.Error(ctx, fiber.StatusInternalServerError, action+": Internal Server Error")
+}
+
+func negative(value *int64) bool {
+	return value != nil && *value < 0
+}
@@ -0,0 +1,50 @@
+package presence
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/presence"
+)
+
+// maxPresenceBatch 单次查询的最大用户数
+const maxPresenceBatch = 200
+
+type Ctrl struct {
+	presenceService *presence.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		presenceService: presence.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) Get(ctx *fiber.Ctx) error {
+	req := &proto.PresenceGetReq{}
+	if err := ctx.BodyParser(req); err != nil || len(req.UIDs) == 0 || len(req.UIDs) > maxPresenceBatch {
+		return response.Error(ctx, fiber.StatusBadRequest, "Get Presence: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	resp, err := ctrl.presenceService.Get(ctx.Context(), uid, req)
+	if err != nil {
+		log.Errorf("get presence error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Get Presence: Internal Server Error")
+	}
+	return response.Success(ctx, resp)
+}
+
+func (ctrl *Ctrl) UpdateSetting(ctx *fiber.Ctx) error {
+	req := &proto.PresenceSettingReq{}
+	if err := ctx.BodyParser(req); err != nil {
+		return response.Error(ctx, fiber.StatusBadRequest, "Update Presence Setting: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.presenceService.UpdateSetting(ctx.Context(), uid, req); err != nil {
+		log.Errorf("update presence setting error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Update Presence Setting: Internal Server Error")
+	}
+	return response.Success(ctx, "Update Presence Setting Success")
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/session"
+)
+
+// maxDeviceNameLen 设备名称的最大字节数
+const maxDeviceNameLen = 64
+
+type Ctrl struct {
+	sessionService *session.Service
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		sessionService: session.NewService(),
+	}
+}
+
+func (ctrl *Ctrl) List(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	return response.Success(ctx, ctrl.sessionService.List(uid))
+}
+
+func (ctrl *Ctrl) Kick(ctx *fiber.Ctx) error {
+	req := &proto.SessionKickReq{}
+	if err := ctx.BodyParser(req); err != nil || req.ConnId == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Kick Session: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.sessionService.Kick(uid, req); err != nil {
+		return response.Error(ctx, fiber.StatusNotFound, "Kick Session: "+err.Error())
+	}
+	return response.Success(ctx, "Kick Session Success")
+}
+
+func (ctrl *Ctrl) Rename(ctx *fiber.Ctx) error {
+	req := &proto.SessionRenameReq{}
+	if err := ctx.BodyParser(req); err != nil || req.ConnId == "" || req.DeviceName == "" || len(req.DeviceName) > maxDeviceNameLen {
+		return response.Error(ctx, fiber.StatusBadRequest, "Rename Session: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	if err := ctrl.sessionService.Rename(uid, req); err != nil {
+		return response.Error(ctx, fiber.StatusNotFound, "Rename Session: "+err.Error())
+	}
+	return response.Success(ctx, "Rename Session Success")
+}
+
+func (ctrl *Ctrl) KickOthers(ctx *fiber.Ctx) error {
+	req := &proto.SessionKickOthersReq{}
+	if err := ctx.BodyParser(req); err != nil {
+		return response.Error(ctx, fiber.StatusBadRequest, "Kick Other Sessions: Bad Request")
+	}
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	return response.Success(ctx, ctrl.sessionService.KickOthers(uid, req))
+}
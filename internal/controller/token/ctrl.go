@@ -0,0 +1,49 @@
+package token
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/helper/response"
+	"github.com/tangthinker/secret-chat-server/internal/middleware"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+	"github.com/tangthinker/secret-chat-server/internal/service/token"
+)
+
+type Ctrl struct {
+	tokenService *token.Service
+	connService  *connections.WebSocketConnections
+}
+
+func New() *Ctrl {
+	return &Ctrl{
+		tokenService: token.Default(),
+		connService:  connections.Default(),
+	}
+}
+
+// Revoke 注销当前请求使用的 token 并断开使用该 token 的长连接
+func (ctrl *Ctrl) Revoke(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(middleware.UIDKey).(string)
+	tk := ctx.Locals(middleware.TokenKey).(string)
+	if err := ctrl.tokenService.Revoke(ctx.Context(), uid, tk); err != nil {
+		log.Errorf("revoke token error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Revoke Token: Internal Server Error")
+	}
+	ctrl.connService.RevokeToken(uid, tk)
+	return response.Success(ctx, "Revoke Token Success")
+}
+
+// RevokeByService 供用户中心等内部服务调用 注销指定 token 并断开所有实例上使用该 token 的长连接
+func (ctrl *Ctrl) RevokeByService(ctx *fiber.Ctx) error {
+	req := &proto.TokenRevokeReq{}
+	if err := ctx.BodyParser(req); err != nil || req.UID == "" || req.Token == "" {
+		return response.Error(ctx, fiber.StatusBadRequest, "Revoke Token: Bad Request")
+	}
+	if err := ctrl.tokenService.Revoke(ctx.Context(), req.UID, req.Token); err != nil {
+		log.Errorf("revoke token error: %s", err)
+		return response.Error(ctx, fiber.StatusInternalServerError, "Revoke Token: Internal Server Error")
+	}
+	ctrl.connService.RevokeToken(req.UID, req.Token)
+	return response.Success(ctx, "Revoke Token Success")
+}
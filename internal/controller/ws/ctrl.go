@@ -1,24 +1,51 @@
 package ws
 
 import (
+	"strconv"
+
 	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/tangthinker/secret-chat-server/core"
 	"github.com/tangthinker/secret-chat-server/internal/middleware"
 	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+	"github.com/tangthinker/secret-chat-server/internal/service/keyring"
 	skep "github.com/tangthinker/skep-server-go/pkg"
 )
 
+// KeyIdHeader 升级响应中携带本次握手使用的签名密钥 ID
+const KeyIdHeader = "X-Key-Id"
+
+const signKeyLocal = "sign-key"
+
 type Ctrl struct {
-	connService *connections.WebSocketConnections
+	connService    *connections.WebSocketConnections
+	keyringService *keyring.Service
 }
 
 func New() *Ctrl {
 	return &Ctrl{
-		connService: connections.NewWebSocketConnections(),
+		connService:    connections.Default(),
+		keyringService: keyring.Default(),
 	}
 }
 
+// SelectKey 升级前选择握手签名密钥
+// 客户端通过 kid 参数指定其固定的公钥 未指定时使用当前生效的密钥
+func (ctrl *Ctrl) SelectKey(ctx *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		return fiber.ErrUpgradeRequired
+	}
+	key, err := ctrl.keyringService.Signing(ctx.Query("kid"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		return ctx.SendString("invalid kid: " + err.Error())
+	}
+	ctx.Locals(signKeyLocal, key.PrivKey)
+	ctx.Set(KeyIdHeader, key.Kid)
+	return ctx.Next()
+}
+
 func (ctrl *Ctrl) HandleConn(conn *websocket.Conn) {
 	uid := conn.Locals(middleware.UIDKey).(string)
 	token := conn.Locals(middleware.TokenKey).(string)
@@ -26,7 +53,7 @@ func (ctrl *Ctrl) HandleConn(conn *websocket.Conn) {
 	mConn := connections.NewConn(conn)
 
 	// 握手
-	ecdsaPrivKey := core.GlobalHelper.Config.GetString("encrypt-conn.ecdsa-priv-key")
+	ecdsaPrivKey := conn.Locals(signKeyLocal).(string)
 	handshakeTimeout := core.GlobalHelper.Config.GetDuration("encrypt-conn.handshake-timeout")
 	skepProcessor := skep.NewSkep(mConn, handshakeTimeout, []string{uid, token}, ecdsaPrivKey)
 	sharedKey, err := skepProcessor.Handshake()
@@ -37,8 +64,21 @@ func (ctrl *Ctrl) HandleConn(conn *websocket.Conn) {
 	}
 
 	mConn.SetEncryptKey(sharedKey)
+	mConn.SetToken(token)
+
+	// 声明了新版本协议的客户端在握手后先发送 hello 协商能力 未声明的按旧协议处理
+	if version, _ := strconv.Atoi(conn.Query("proto")); version >= connections.ProtocolVersion2 {
+		helloTimeout := core.GlobalHelper.Config.GetDuration("websocket.hello-timeout")
+		if err := mConn.Negotiate(helloTimeout); err != nil {
+			log.Errorf("negotiate failed, uid: %s, err: %v", uid, err)
+			mConn.Close()
+			return
+		}
+	}
 
-	ctrl.connService.AddConnection(uid, mConn)
+	// 客户端重连时携带最后收到的序号 仅补发缺失部分
+	lastSeq, _ := strconv.ParseUint(conn.Query("last_seq"), 10, 64)
+	ctrl.connService.AddConnection(uid, mConn, lastSeq)
 	for {
 		message, err := mConn.ReadMessage()
 		if err != nil {
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tangthinker/secret-chat-server/core"
+)
+
+// AdminValid 校验当前用户是否为配置中的管理员
+func AdminValid(ctx *fiber.Ctx) error {
+	uid := ctx.Locals(UIDKey).(string)
+	if !slices.Contains(core.GlobalHelper.Config.GetStringSlice("admin.uids"), uid) {
+		ctx.Status(fiber.StatusForbidden)
+		return ctx.SendString("Forbidden: Admin Only")
+	}
+	return ctx.Next()
+}
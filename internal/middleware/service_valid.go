@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tangthinker/secret-chat-server/core"
+)
+
+// ServiceSecretHeader 服务间调用携带共享密钥的请求头
+const ServiceSecretHeader = "X-Service-Secret"
+
+// ServiceValid 校验服务间调用的共享密钥 未配置密钥时拒绝所有请求
+func ServiceValid(ctx *fiber.Ctx) error {
+	secret := core.GlobalHelper.Config.GetString("service.secret")
+	provided := ctx.Get(ServiceSecretHeader)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(provided)) != 1 {
+		ctx.Status(fiber.StatusForbidden)
+		return ctx.SendString("Forbidden: Invalid Service Secret")
+	}
+	return ctx.Next()
+}
@@ -4,7 +4,7 @@ import (
 	"log"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/tangthinker/user-center/pkg"
+	tokenService "github.com/tangthinker/secret-chat-server/internal/service/token"
 )
 
 func sendForbidden(ctx *fiber.Ctx) error {
@@ -39,7 +39,7 @@ func TokenValid(ctx *fiber.Ctx) error {
 	}
 
 	// 验证 token
-	uid, err := pkg.TokenValid(token)
+	uid, err := tokenService.Default().Verify(ctx.Context(), token)
 	if err != nil {
 		ctx.Status(fiber.StatusUnauthorized)
 		return ctx.SendString("Unauthorized: " + err.Error())
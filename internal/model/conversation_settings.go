@@ -0,0 +1,88 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ConversationSettingsModel struct {
+	db *gorm.DB
+}
+
+func NewConversationSettingsModel() *ConversationSettingsModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.ConversationSettings{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &ConversationSettingsModel{db: d}
+}
+
+// Get 会话没有设置时返回 nil
+func (m *ConversationSettingsModel) Get(ctx context.Context, conversationId string) (*schema.ConversationSettings, error) {
+	var settings schema.ConversationSettings
+	if err := m.db.WithContext(ctx).Where("conversation_id = ?", conversationId).First(&settings).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Upsert 会话没有设置时创建 否则只更新 columns 中的字段以及 updated_by 和 updated_at
+func (m *ConversationSettingsModel) Upsert(ctx context.Context, req *schema.ConversationSettings, columns []string) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns(append(columns, "updated_by", "updated_at")),
+	}).Create(req).Error
+}
+
+// SetHistoryOptIn 在一条语句内更新单聊中 uid 的历史记录选择 并按所有参与者是否都已开启更新 history_enabled
+// history_enabled_by 以 ",uid," 的形式保存 兼容旧的 "a,b" 格式
+func (m *ConversationSettingsModel) SetHistoryOptIn(ctx context.Context, conversationId string, uid string, participants []string, enabled bool) error {
+	marker := "," + uid + ","
+	current := gorm.Expr("(',' || trim(history_enabled_by, ',') || ',')")
+	next := gorm.Expr("CASE WHEN replace(?, ?, ',') = ',' THEN '' ELSE replace(?, ?, ',') END", current, marker, current, marker)
+	if enabled {
+		next = gorm.Expr("CASE WHEN trim(history_enabled_by, ',') = '' THEN ? WHEN instr(?, ?) > 0 THEN ? ELSE ? || ? END",
+			marker, current, marker, current, current, uid+",")
+	}
+	all := gorm.Expr("1")
+	for _, participant := range participants {
+		all = gorm.Expr("? AND instr(?, ?) > 0", all, next, ","+participant+",")
+	}
+
+	settings := &schema.ConversationSettings{ConversationId: conversationId, UpdatedBy: uid}
+	if enabled {
+		settings.HistoryEnabledBy = marker
+		settings.HistoryEnabled = true
+		for _, participant := range participants {
+			settings.HistoryEnabled = settings.HistoryEnabled && participant == uid
+		}
+	}
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "conversation_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"history_enabled_by": next,
+			"history_enabled":    all,
+			"updated_by":         uid,
+			"updated_at":         time.Now(),
+		}),
+	}).Create(settings).Error
+}
+
+// ListWithRetention 设置了保留时长的会话
+func (m *ConversationSettingsModel) ListWithRetention(ctx context.Context) ([]*schema.ConversationSettings, error) {
+	var list []*schema.ConversationSettings
+	if err := m.db.WithContext(ctx).Where("retention_seconds > 0").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
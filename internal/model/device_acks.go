@@ -0,0 +1,52 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DeviceAcksModel struct {
+	db *gorm.DB
+}
+
+func NewDeviceAcksModel() *DeviceAcksModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.DeviceAcks{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &DeviceAcksModel{db: d}
+}
+
+// Advance 登记设备并推进其已确认序号 序号只增不减
+func (m *DeviceAcksModel) Advance(ctx context.Context, uid string, deviceId string, seq uint64) error {
+	now := time.Now()
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "uid"}, {Name: "device_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"acked_seq":  gorm.Expr("max(acked_seq, ?)", seq),
+			"last_seen":  now,
+			"updated_at": now,
+		}),
+	}).Create(&schema.DeviceAcks{UID: uid, DeviceId: deviceId, AckedSeq: seq, LastSeen: now}).Error
+}
+
+// ListActive since 之后出现过的设备
+func (m *DeviceAcksModel) ListActive(ctx context.Context, uid string, since time.Time) ([]*schema.DeviceAcks, error) {
+	var list []*schema.DeviceAcks
+	if err := m.db.WithContext(ctx).Where("uid = ? and last_seen >= ?", uid, since).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteInactive 清理 before 之前就不再出现的设备
+func (m *DeviceAcksModel) DeleteInactive(ctx context.Context, before time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Unscoped().Where("last_seen < ?", before).Delete(&schema.DeviceAcks{})
+	return result.RowsAffected, result.Error
+}
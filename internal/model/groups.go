@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupsModel struct {
+	db *gorm.DB
+}
+
+func NewGroupsModel() *GroupsModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.Groups{}, &schema.GroupMembers{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &GroupsModel{db: d}
+}
+
+// Create 创建群组 并写入初始成员
+func (m *GroupsModel) Create(ctx context.Context, group *schema.Groups, members []string) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+		return addMembers(tx, group.GroupId, members)
+	})
+}
+
+func (m *GroupsModel) GetByGroupId(ctx context.Context, groupId string) (*schema.Groups, error) {
+	var group schema.Groups
+	if err := m.db.WithContext(ctx).Where("group_id = ?", groupId).First(&group).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (m *GroupsModel) Rename(ctx context.Context, groupId string, name string) error {
+	return m.db.WithContext(ctx).Model(&schema.Groups{}).Where("group_id = ?", groupId).Update("name", name).Error
+}
+
+func (m *GroupsModel) AddMembers(ctx context.Context, groupId string, uids []string) error {
+	return addMembers(m.db.WithContext(ctx), groupId, uids)
+}
+
+// RemoveMembers 移除成员 使用硬删除以便成员可以被重新加入
+func (m *GroupsModel) RemoveMembers(ctx context.Context, groupId string, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return m.db.WithContext(ctx).Unscoped().
+		Where("group_id = ? AND uid in (?)", groupId, uids).
+		Delete(&schema.GroupMembers{}).Error
+}
+
+func (m *GroupsModel) GetMemberUids(ctx context.Context, groupId string) ([]string, error) {
+	var uids []string
+	if err := m.db.WithContext(ctx).Model(&schema.GroupMembers{}).
+		Where("group_id = ?", groupId).
+		Order("id").
+		Pluck("uid", &uids).Error; err != nil {
+		return nil, err
+	}
+	return uids, nil
+}
+
+// GetMember 用户不是群成员时返回 gorm.ErrRecordNotFound 成员的 CreatedAt 即加入时间
+func (m *GroupsModel) GetMember(ctx context.Context, groupId string, uid string) (*schema.GroupMembers, error) {
+	var member schema.GroupMembers
+	if err := m.db.WithContext(ctx).Where("group_id = ? AND uid = ?", groupId, uid).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (m *GroupsModel) IsMember(ctx context.Context, groupId string, uid string) (bool, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&schema.GroupMembers{}).
+		Where("group_id = ? AND uid = ?", groupId, uid).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func addMembers(tx *gorm.DB, groupId string, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	members := make([]*schema.GroupMembers, 0, len(uids))
+	for _, uid := range uids {
+		members = append(members, &schema.GroupMembers{
+			GroupId: groupId,
+			Uid:     uid,
+		})
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
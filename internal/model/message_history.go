@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MessageHistoryModel struct {
+	db *gorm.DB
+}
+
+func NewMessageHistoryModel() *MessageHistoryModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.MessageHistory{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &MessageHistoryModel{db: d}
+}
+
+func (m *MessageHistoryModel) Create(ctx context.Context, req *schema.MessageHistory) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(req).Error
+}
+
+func (m *MessageHistoryModel) GetByMsgId(ctx context.Context, msgId string) (*schema.MessageHistory, error) {
+	var history schema.MessageHistory
+	if err := m.db.WithContext(ctx).Where("msg_id = ?", msgId).First(&history).Error; err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// List 按 ID 分页 beforeId 和 afterId 为 0 时不限制 since 为零值时不限制写入时间 返回结果按 ID 升序
+// 只指定 afterId 时返回其后最早的 limit 条 否则返回范围内最新的 limit 条
+func (m *MessageHistoryModel) List(ctx context.Context, conversationId string, since time.Time, beforeId uint, afterId uint, limit int) ([]*schema.MessageHistory, error) {
+	query := m.db.WithContext(ctx).Where("conversation_id = ?", conversationId)
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	if beforeId > 0 {
+		query = query.Where("id < ?", beforeId)
+	}
+	if afterId > 0 {
+		query = query.Where("id > ?", afterId)
+	}
+	order := "id desc"
+	if afterId > 0 && beforeId == 0 {
+		order = "id asc"
+	}
+	var list []*schema.MessageHistory
+	if err := query.Order(order).Limit(limit).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	if order == "id desc" {
+		slices.Reverse(list)
+	}
+	return list, nil
+}
+
+func (m *MessageHistoryModel) UpdateContent(ctx context.Context, msgId string, content string) error {
+	return m.db.WithContext(ctx).Model(&schema.MessageHistory{}).Where("msg_id = ?", msgId).Update("content", content).Error
+}
+
+func (m *MessageHistoryModel) DeleteByMsgId(ctx context.Context, msgId string) error {
+	return m.db.WithContext(ctx).Unscoped().Where("msg_id = ?", msgId).Delete(&schema.MessageHistory{}).Error
+}
+
+// DeleteBefore 清理会话中 before 之前写入的历史消息
+func (m *MessageHistoryModel) DeleteBefore(ctx context.Context, conversationId string, before time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Unscoped().Where("conversation_id = ? and created_at < ?", conversationId, before).Delete(&schema.MessageHistory{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+)
+
+type MessageMetaModel struct {
+	db *gorm.DB
+}
+
+func NewMessageMetaModel() *MessageMetaModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.MessageMeta{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &MessageMetaModel{db: d}
+}
+
+func (m *MessageMetaModel) Create(ctx context.Context, req *schema.MessageMeta) error {
+	return m.db.WithContext(ctx).Create(req).Error
+}
+
+func (m *MessageMetaModel) GetByMsgId(ctx context.Context, msgId string) (*schema.MessageMeta, error) {
+	var meta schema.MessageMeta
+	if err := m.db.WithContext(ctx).Where("msg_id = ?", msgId).First(&meta).Error; err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// DeleteBefore 清理 before 之前创建的元信息 设置了过期的元信息保留到过期时由过期任务删除
+func (m *MessageMetaModel) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Unscoped().
+		Where("created_at < ? and expire_at is null and expire_after_read = 0", before).
+		Delete(&schema.MessageMeta{})
+	return result.RowsAffected, result.Error
+}
+
+// SetExpireAt 设置过期时间 已有更早的过期时间时不修改
+func (m *MessageMetaModel) SetExpireAt(ctx context.Context, msgId string, expireAt time.Time) error {
+	return m.db.WithContext(ctx).Model(&schema.MessageMeta{}).
+		Where("msg_id = ? and (expire_at is null or expire_at > ?)", msgId, expireAt).
+		Update("expire_at", expireAt).Error
+}
+
+// ListExpired 过期时间早于 now 的元信息
+func (m *MessageMetaModel) ListExpired(ctx context.Context, now time.Time, limit int) ([]*schema.MessageMeta, error) {
+	var list []*schema.MessageMeta
+	if err := m.db.WithContext(ctx).Where("expire_at <= ?", now).Order("expire_at asc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteByMsgId 返回删除的行数 多实例同时清理时只有删除成功的实例继续处理
+func (m *MessageMetaModel) DeleteByMsgId(ctx context.Context, msgId string) (int64, error) {
+	result := m.db.WithContext(ctx).Unscoped().Where("msg_id = ?", msgId).Delete(&schema.MessageMeta{})
+	return result.RowsAffected, result.Error
+}
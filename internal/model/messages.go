@@ -7,6 +7,7 @@ import (
 	"github.com/tangthinker/secret-chat-server/core"
 	"github.com/tangthinker/secret-chat-server/internal/model/schema"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessagesModel struct {
@@ -15,7 +16,7 @@ type MessagesModel struct {
 
 func NewMessagesModel() *MessagesModel {
 	d := core.GlobalHelper.DB.GetDB()
-	if err := d.AutoMigrate(&schema.Messages{}); err != nil {
+	if err := d.AutoMigrate(&schema.Messages{}, &schema.UserSequences{}); err != nil {
 		panic(fmt.Sprintf("auto migrate err:%v", err))
 	}
 	return &MessagesModel{db: d}
@@ -23,16 +24,125 @@ func NewMessagesModel() *MessagesModel {
 
 func (m *MessagesModel) GetListByUid(ctx context.Context, uid string) ([]*schema.Messages, error) {
 	var result []*schema.Messages
-	if err := m.db.WithContext(ctx).Where("uid = ?", uid).Find(&result).Error; err != nil {
+	if err := m.db.WithContext(ctx).Where("uid = ?", uid).Order("seq, id").Find(&result).Error; err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
+// GetListByUidAfterSeq 获取序号大于 seq 的消息 按序号升序 未分配序号的历史消息一并返回
+func (m *MessagesModel) GetListByUidAfterSeq(ctx context.Context, uid string, seq uint64) ([]*schema.Messages, error) {
+	var result []*schema.Messages
+	if err := m.db.WithContext(ctx).
+		Where("uid = ? AND (seq > ? OR seq = 0)", uid, seq).
+		Order("seq, id").
+		Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MarkAcked 记录设备按消息id的确认
+func (m *MessagesModel) MarkAcked(ctx context.Context, uid string, deviceId string, msgIds []string) error {
+	marker := "," + deviceId + ","
+	return m.db.WithContext(ctx).Model(&schema.Messages{}).
+		Where("uid = ? AND msg_id in (?) AND instr(acked_devices, ?) = 0", uid, msgIds, marker).
+		Update("acked_devices", gorm.Expr("CASE WHEN acked_devices = '' THEN ? ELSE acked_devices || ? END", marker, deviceId+",")).Error
+}
+
+// NextSeq 为 uid 分配下一个消息序号
+func (m *MessagesModel) NextSeq(ctx context.Context, uid string) (uint64, error) {
+	var seq uint64
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "uid"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"seq": gorm.Expr("seq + 1")}),
+		}).Create(&schema.UserSequences{UID: uid, Seq: 1}).Error
+		if err != nil {
+			return err
+		}
+		var us schema.UserSequences
+		if err := tx.Where("uid = ?", uid).First(&us).Error; err != nil {
+			return err
+		}
+		seq = us.Seq
+		return nil
+	})
+	return seq, err
+}
+
+// NextSeqs 在一个事务内为每个 uid 分配下一个消息序号
+func (m *MessagesModel) NextSeqs(ctx context.Context, uids []string) (map[string]uint64, error) {
+	seqs := make(map[string]uint64, len(uids))
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rows := make([]*schema.UserSequences, 0, len(uids))
+		for _, uid := range uids {
+			rows = append(rows, &schema.UserSequences{UID: uid, Seq: 1})
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "uid"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"seq": gorm.Expr("seq + 1")}),
+		}).Create(&rows).Error
+		if err != nil {
+			return err
+		}
+		var list []*schema.UserSequences
+		if err := tx.Where("uid in (?)", uids).Find(&list).Error; err != nil {
+			return err
+		}
+		for _, us := range list {
+			seqs[us.UID] = us.Seq
+		}
+		return nil
+	})
+	return seqs, err
+}
+
 func (m *MessagesModel) Delete(ctx context.Context, msgIds []uint) error {
 	return m.db.WithContext(ctx).Delete(&schema.Messages{}, "id in (?)", msgIds).Error
 }
 
+// DeleteAcked 删除每个设备都已确认的消息 与 schema.Messages.AckedBy 的判断一致
+// 设备按序号 按消息id 或作为发出设备确认均可 msgIds 不为 nil 时只删除这些消息
+func (m *MessagesModel) DeleteAcked(ctx context.Context, uid string, devices []*schema.DeviceAcks, msgIds []string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	query := m.db.WithContext(ctx).Where("uid = ? AND msg_id <> ''", uid)
+	if msgIds != nil {
+		query = query.Where("msg_id in (?)", msgIds)
+	}
+	for _, device := range devices {
+		query = query.Where("((seq > 0 AND seq <= ?) OR exclude_device = ? OR instr(acked_devices, ?) > 0)",
+			device.AckedSeq, device.DeviceId, ","+device.DeviceId+",")
+	}
+	return query.Delete(&schema.Messages{}).Error
+}
+
+// DeleteByMsgIds 删除 uid 下指定消息id的离线消息
+func (m *MessagesModel) DeleteByMsgIds(ctx context.Context, uid string, msgIds []string) error {
+	return m.db.WithContext(ctx).Delete(&schema.Messages{}, "uid = ? AND msg_id in (?)", uid, msgIds).Error
+}
+
+// DeleteAllByMsgId 删除所有接收方尚未确认的同一条消息
+func (m *MessagesModel) DeleteAllByMsgId(ctx context.Context, msgId string) error {
+	return m.db.WithContext(ctx).Delete(&schema.Messages{}, "msg_id = ?", msgId).Error
+}
+
+func (m *MessagesModel) ExistsByMsgId(ctx context.Context, uid string, msgId string) (bool, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&schema.Messages{}).
+		Where("uid = ? AND msg_id = ?", uid, msgId).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func (m *MessagesModel) Create(ctx context.Context, req *schema.Messages) error {
 	return m.db.WithContext(ctx).Create(req).Error
 }
+
+func (m *MessagesModel) CreateBatch(ctx context.Context, list []*schema.Messages) error {
+	return m.db.WithContext(ctx).Create(&list).Error
+}
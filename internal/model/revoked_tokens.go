@@ -0,0 +1,44 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RevokedTokensModel struct {
+	db *gorm.DB
+}
+
+func NewRevokedTokensModel() *RevokedTokensModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.RevokedTokens{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &RevokedTokensModel{db: d}
+}
+
+func (m *RevokedTokensModel) Create(ctx context.Context, req *schema.RevokedTokens) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(req).Error
+}
+
+func (m *RevokedTokensModel) Exists(ctx context.Context, tokenHash string) (bool, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&schema.RevokedTokens{}).
+		Where("token_hash = ?", tokenHash).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteBefore 清理 before 之前注销的记录
+func (m *RevokedTokensModel) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := m.db.WithContext(ctx).Unscoped().Where("created_at < ?", before).Delete(&schema.RevokedTokens{})
+	return result.RowsAffected, result.Error
+}
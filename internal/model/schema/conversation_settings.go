@@ -0,0 +1,22 @@
+package schema
+
+import "gorm.io/gorm"
+
+// ConversationSettings 会话级设置 会话内所有成员共享
+type ConversationSettings struct {
+	gorm.Model
+	ConversationId string `gorm:"type:varchar(300);not null;uniqueIndex" json:"conversation_id"`
+	HistoryEnabled bool   `gorm:"not null;default:false" json:"history_enabled"`
+	// HistoryEnabledBy 单聊中已开启历史记录的用户 以 ",uid," 的形式保存 双方都开启后 HistoryEnabled 才为 true
+	HistoryEnabledBy string `gorm:"type:varchar(300);not null;default:''" json:"history_enabled_by"`
+	// RetentionSeconds 历史消息保留时长 0 表示永久保留
+	RetentionSeconds int64 `gorm:"not null;default:0" json:"retention_seconds"`
+	// ExpireSeconds 与 ExpireAfterReadSeconds 为会话内消息默认的过期设置 0 表示不启用
+	ExpireSeconds          int64  `gorm:"not null;default:0" json:"expire_seconds"`
+	ExpireAfterReadSeconds int64  `gorm:"not null;default:0" json:"expire_after_read_seconds"`
+	UpdatedBy              string `gorm:"type:varchar(128);not null;default:''" json:"updated_by"`
+}
+
+func (cs *ConversationSettings) TableName() string {
+	return "conversation_settings"
+}
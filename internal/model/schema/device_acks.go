@@ -0,0 +1,20 @@
+package schema
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceAcks 用户每个设备已确认的最大序号 离线消息在所有活跃设备都确认后才删除
+type DeviceAcks struct {
+	gorm.Model
+	UID      string    `gorm:"type:varchar(128);not null;uniqueIndex:idx_device_acks_uid_device"`
+	DeviceId string    `gorm:"type:varchar(128);not null;uniqueIndex:idx_device_acks_uid_device"`
+	AckedSeq uint64    `gorm:"not null;default:0"`
+	LastSeen time.Time `gorm:"not null"`
+}
+
+func (da *DeviceAcks) TableName() string {
+	return "device_acks"
+}
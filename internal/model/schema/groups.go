@@ -0,0 +1,24 @@
+package schema
+
+import "gorm.io/gorm"
+
+type Groups struct {
+	gorm.Model
+	GroupId string `gorm:"type:varchar(64);not null;uniqueIndex" json:"group_id"`
+	Name    string `gorm:"type:varchar(128);not null;default:''" json:"name"`
+	Owner   string `gorm:"type:varchar(128);not null" json:"owner"`
+}
+
+func (g *Groups) TableName() string {
+	return "chat_groups"
+}
+
+type GroupMembers struct {
+	gorm.Model
+	GroupId string `gorm:"type:varchar(64);not null;uniqueIndex:idx_group_member" json:"group_id"`
+	Uid     string `gorm:"type:varchar(128);not null;uniqueIndex:idx_group_member;index" json:"uid"`
+}
+
+func (gm *GroupMembers) TableName() string {
+	return "group_members"
+}
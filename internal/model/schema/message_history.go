@@ -0,0 +1,17 @@
+package schema
+
+import "gorm.io/gorm"
+
+// MessageHistory 开启了历史记录的会话的消息日志 按 ID 递增排序
+type MessageHistory struct {
+	gorm.Model
+	ConversationId string `gorm:"type:varchar(300);not null;index"`
+	MsgId          string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	FromUid        string `gorm:"type:varchar(128);not null"`
+	// Content 完整的消息 json
+	Content string `gorm:"type:text"`
+}
+
+func (mh *MessageHistory) TableName() string {
+	return "message_history"
+}
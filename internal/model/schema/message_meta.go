@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MessageMeta 已发送消息的元信息 用于撤回和编辑时校验发送方与时间窗口 以及阅后即焚消息的过期
+type MessageMeta struct {
+	gorm.Model
+	MsgId       string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	FromUid     string `gorm:"type:varchar(128);not null"`
+	Destination string `gorm:"type:varchar(128);not null"`
+	MessageType int    `gorm:"not null"`
+	// Recipients 发送时的接收方 逗号分隔 不含发送方 撤回、编辑与过期通知只发给这些用户
+	Recipients string `gorm:"type:text;not null;default:''"`
+	// ExpireAt 消息过期删除的时间 为空表示不过期
+	ExpireAt *time.Time `gorm:"index"`
+	// ExpireAfterRead 首次被阅读后多少秒过期 0 表示不启用
+	ExpireAfterRead int64 `gorm:"not null;default:0"`
+}
+
+func (mm *MessageMeta) TableName() string {
+	return "message_meta"
+}
+
+// RecipientList 发送时记录的接收方 旧记录没有接收方时返回 nil
+func (mm *MessageMeta) RecipientList() []string {
+	if mm.Recipients == "" {
+		return nil
+	}
+	return strings.Split(mm.Recipients, ",")
+}
@@ -1,11 +1,32 @@
 package schema
 
-import "gorm.io/gorm"
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
 
 type Messages struct {
 	gorm.Model
-	Uid     string `gorm:"type:varchar(255);not null"`
+	Uid     string `gorm:"type:varchar(255);not null;index:idx_messages_uid_seq"`
+	Seq     uint64 `gorm:"not null;default:0;index:idx_messages_uid_seq"`
+	MsgId   string `gorm:"type:varchar(64);not null;default:'';index"`
 	Content string `gorm:"type:text"`
+	// ExcludeDevice 补发时跳过的设备 用于发送方多设备同步时排除发出消息的设备
+	ExcludeDevice string `gorm:"type:varchar(128);not null;default:''"`
+	// AckedDevices 已按消息id确认的设备 格式为 ,device1,device2,
+	AckedDevices string `gorm:"type:text;not null;default:''"`
+}
+
+// AckedBy 设备是否已确认该消息 发出该消息的设备视为已确认
+func (m *Messages) AckedBy(deviceId string, ackedSeq uint64) bool {
+	if m.Seq > 0 && m.Seq <= ackedSeq {
+		return true
+	}
+	if m.ExcludeDevice != "" && m.ExcludeDevice == deviceId {
+		return true
+	}
+	return strings.Contains(m.AckedDevices, ","+deviceId+",")
 }
 
 func (m *Messages) TableName() string {
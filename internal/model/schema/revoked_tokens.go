@@ -0,0 +1,14 @@
+package schema
+
+import "gorm.io/gorm"
+
+// RevokedTokens 已注销的 token 只保存摘要
+type RevokedTokens struct {
+	gorm.Model
+	TokenHash string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Uid       string `gorm:"type:varchar(128);not null"`
+}
+
+func (rt *RevokedTokens) TableName() string {
+	return "revoked_tokens"
+}
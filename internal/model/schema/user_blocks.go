@@ -0,0 +1,13 @@
+package schema
+
+import "gorm.io/gorm"
+
+type UserBlocks struct {
+	gorm.Model
+	UID        string `gorm:"type:varchar(128);not null;uniqueIndex:idx_user_blocked" json:"uid"`
+	BlockedUID string `gorm:"type:varchar(128);not null;uniqueIndex:idx_user_blocked" json:"blocked_uid"`
+}
+
+func (ub *UserBlocks) TableName() string {
+	return "user_blocks"
+}
@@ -0,0 +1,19 @@
+package schema
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserPresence struct {
+	gorm.Model
+	UID      string     `gorm:"type:varchar(128);not null;uniqueIndex" json:"uid"`
+	LastSeen *time.Time `json:"last_seen"`
+	// Hidden 隐藏在线状态 不推送上下线事件 也不对外返回最后在线时间
+	Hidden bool `gorm:"not null;default:false" json:"hidden"`
+}
+
+func (up *UserPresence) TableName() string {
+	return "user_presence"
+}
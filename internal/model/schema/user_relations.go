@@ -2,8 +2,33 @@ package schema
 
 import "gorm.io/gorm"
 
+// UserRelations 好友关系 每对好友保存双向两条记录
 type UserRelations struct {
 	gorm.Model
-	UID       string `gorm:"unique"`
-	FriendIds string `gorm:"type:text"`
+	UID       string `gorm:"type:varchar(128);not null;uniqueIndex:idx_user_friend" json:"uid"`
+	FriendUID string `gorm:"type:varchar(128);not null;uniqueIndex:idx_user_friend" json:"friend_uid"`
+}
+
+func (ur *UserRelations) TableName() string {
+	return "user_relations"
+}
+
+type FriendRequestStatus int
+
+const (
+	FriendRequestPending  FriendRequestStatus = 0
+	FriendRequestAccepted FriendRequestStatus = 1
+	FriendRequestDeclined FriendRequestStatus = 2
+)
+
+type FriendRequests struct {
+	gorm.Model
+	FromUID string              `gorm:"type:varchar(128);not null;index" json:"from_uid"`
+	ToUID   string              `gorm:"type:varchar(128);not null;index" json:"to_uid"`
+	Remark  string              `gorm:"type:varchar(255);not null;default:''" json:"remark"`
+	Status  FriendRequestStatus `gorm:"not null;default:0" json:"status"`
+}
+
+func (fr *FriendRequests) TableName() string {
+	return "friend_requests"
 }
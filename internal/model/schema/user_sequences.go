@@ -0,0 +1,14 @@
+package schema
+
+import "gorm.io/gorm"
+
+// UserSequences 每个接收方当前已分配的最大消息序号
+type UserSequences struct {
+	gorm.Model
+	UID string `gorm:"type:varchar(128);not null;uniqueIndex"`
+	Seq uint64 `gorm:"not null;default:0"`
+}
+
+func (us *UserSequences) TableName() string {
+	return "user_sequences"
+}
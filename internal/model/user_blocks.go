@@ -0,0 +1,77 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserBlocksModel struct {
+	db *gorm.DB
+}
+
+func NewUserBlocksModel() *UserBlocksModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.UserBlocks{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &UserBlocksModel{db: d}
+}
+
+func (m *UserBlocksModel) Block(ctx context.Context, uid string, blockedUid string) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&schema.UserBlocks{
+		UID:        uid,
+		BlockedUID: blockedUid,
+	}).Error
+}
+
+func (m *UserBlocksModel) Unblock(ctx context.Context, uid string, blockedUid string) error {
+	return m.db.WithContext(ctx).Unscoped().
+		Where("uid = ? AND blocked_uid = ?", uid, blockedUid).
+		Delete(&schema.UserBlocks{}).Error
+}
+
+func (m *UserBlocksModel) ListBlocked(ctx context.Context, uid string) ([]string, error) {
+	var blocked []string
+	if err := m.db.WithContext(ctx).Model(&schema.UserBlocks{}).
+		Where("uid = ?", uid).
+		Order("id").
+		Pluck("blocked_uid", &blocked).Error; err != nil {
+		return nil, err
+	}
+	return blocked, nil
+}
+
+// ListBlockedEither 与 uid 之间任意一方屏蔽了另一方的用户
+func (m *UserBlocksModel) ListBlockedEither(ctx context.Context, uid string) (map[string]bool, error) {
+	var list []*schema.UserBlocks
+	if err := m.db.WithContext(ctx).
+		Where("uid = ? OR blocked_uid = ?", uid, uid).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool, len(list))
+	for _, block := range list {
+		if block.UID == uid {
+			blocked[block.BlockedUID] = true
+		} else {
+			blocked[block.UID] = true
+		}
+	}
+	return blocked, nil
+}
+
+// IsBlocked uid 是否屏蔽了 blockedUid
+func (m *UserBlocksModel) IsBlocked(ctx context.Context, uid string, blockedUid string) (bool, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&schema.UserBlocks{}).
+		Where("uid = ? AND blocked_uid = ?", uid, blockedUid).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
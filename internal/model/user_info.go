@@ -40,6 +40,14 @@ func (ui *UserInfoModel) Exists(ctx context.Context, uid string) (bool, error) {
 	return count > 0, nil
 }
 
+func (ui *UserInfoModel) ListUids(ctx context.Context) ([]string, error) {
+	var uids []string
+	if err := ui.db.WithContext(ctx).Model(&schema.UserInfo{}).Pluck("uid", &uids).Error; err != nil {
+		return nil, err
+	}
+	return uids, nil
+}
+
 func (ui *UserInfoModel) Save(ctx context.Context, req *schema.UserInfo) error {
 	tbName := req.TableName()
 
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserPresenceModel struct {
+	db *gorm.DB
+}
+
+func NewUserPresenceModel() *UserPresenceModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.UserPresence{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &UserPresenceModel{db: d}
+}
+
+func (m *UserPresenceModel) UpdateLastSeen(ctx context.Context, uid string, lastSeen time.Time) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"last_seen": lastSeen, "updated_at": time.Now()}),
+	}).Create(&schema.UserPresence{UID: uid, LastSeen: &lastSeen}).Error
+}
+
+func (m *UserPresenceModel) SetHidden(ctx context.Context, uid string, hidden bool) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"hidden": hidden, "updated_at": time.Now()}),
+	}).Create(&schema.UserPresence{UID: uid, Hidden: hidden}).Error
+}
+
+func (m *UserPresenceModel) IsHidden(ctx context.Context, uid string) (bool, error) {
+	var presence schema.UserPresence
+	if err := m.db.WithContext(ctx).Where("uid = ?", uid).First(&presence).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return presence.Hidden, nil
+}
+
+func (m *UserPresenceModel) GetByUids(ctx context.Context, uids []string) (map[string]*schema.UserPresence, error) {
+	var list []*schema.UserPresence
+	if err := m.db.WithContext(ctx).Where("uid in (?)", uids).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]*schema.UserPresence, len(list))
+	for _, presence := range list {
+		result[presence.UID] = presence
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UserRelationsModel struct {
+	db *gorm.DB
+}
+
+func NewUserRelationsModel() *UserRelationsModel {
+	d := core.GlobalHelper.DB.GetDB()
+	if err := d.AutoMigrate(&schema.UserRelations{}, &schema.FriendRequests{}); err != nil {
+		panic(fmt.Sprintf("auto migrate err:%v", err))
+	}
+	return &UserRelationsModel{db: d}
+}
+
+func (m *UserRelationsModel) CreateRequest(ctx context.Context, req *schema.FriendRequests) error {
+	return m.db.WithContext(ctx).Create(req).Error
+}
+
+func (m *UserRelationsModel) GetRequest(ctx context.Context, requestId uint) (*schema.FriendRequests, error) {
+	var req schema.FriendRequests
+	if err := m.db.WithContext(ctx).Where("id = ?", requestId).First(&req).Error; err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (m *UserRelationsModel) GetPendingRequest(ctx context.Context, fromUid string, toUid string) (*schema.FriendRequests, error) {
+	var req schema.FriendRequests
+	if err := m.db.WithContext(ctx).
+		Where("from_uid = ? AND to_uid = ? AND status = ?", fromUid, toUid, schema.FriendRequestPending).
+		First(&req).Error; err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// ListPendingRequests 获取发给 uid 且尚未处理的好友申请
+func (m *UserRelationsModel) ListPendingRequests(ctx context.Context, uid string) ([]*schema.FriendRequests, error) {
+	var result []*schema.FriendRequests
+	if err := m.db.WithContext(ctx).
+		Where("to_uid = ? AND status = ?", uid, schema.FriendRequestPending).
+		Order("id desc").
+		Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (m *UserRelationsModel) DeclineRequest(ctx context.Context, requestId uint) error {
+	return m.db.WithContext(ctx).Model(&schema.FriendRequests{}).
+		Where("id = ?", requestId).
+		Update("status", schema.FriendRequestDeclined).Error
+}
+
+// AcceptRequest 同意好友申请 并写入双向好友关系
+func (m *UserRelationsModel) AcceptRequest(ctx context.Context, req *schema.FriendRequests) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&schema.FriendRequests{}).
+			Where("id = ?", req.ID).
+			Update("status", schema.FriendRequestAccepted).Error; err != nil {
+			return err
+		}
+		relations := []*schema.UserRelations{
+			{UID: req.FromUID, FriendUID: req.ToUID},
+			{UID: req.ToUID, FriendUID: req.FromUID},
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&relations).Error
+	})
+}
+
+func (m *UserRelationsModel) ListFriends(ctx context.Context, uid string) ([]string, error) {
+	var friends []string
+	if err := m.db.WithContext(ctx).Model(&schema.UserRelations{}).
+		Where("uid = ?", uid).
+		Order("id").
+		Pluck("friend_uid", &friends).Error; err != nil {
+		return nil, err
+	}
+	return friends, nil
+}
+
+func (m *UserRelationsModel) IsFriend(ctx context.Context, uid string, friendUid string) (bool, error) {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&schema.UserRelations{}).
+		Where("uid = ? AND friend_uid = ?", uid, friendUid).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RemoveFriend 删除双向好友关系 使用硬删除以便之后可以重新添加
+func (m *UserRelationsModel) RemoveFriend(ctx context.Context, uid string, friendUid string) error {
+	return m.db.WithContext(ctx).Unscoped().
+		Where("(uid = ? AND friend_uid = ?) OR (uid = ? AND friend_uid = ?)", uid, friendUid, friendUid, uid).
+		Delete(&schema.UserRelations{}).Error
+}
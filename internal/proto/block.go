@@ -0,0 +1,9 @@
+package proto
+
+type BlockReq struct {
+	UID string `json:"uid"`
+}
+
+type BlockListResp struct {
+	Blocked []string `json:"blocked"`
+}
@@ -0,0 +1,9 @@
+package proto
+
+type BroadcastReq struct {
+	Content string `json:"content"`
+}
+
+type BroadcastStatusReq struct {
+	Id string `json:"id"`
+}
@@ -0,0 +1,34 @@
+package proto
+
+import "time"
+
+type DiscoveryKey struct {
+	Kid       string     `json:"kid"`
+	PubKey    string     `json:"pub_key"`
+	Status    string     `json:"status"`
+	NotBefore time.Time  `json:"not_before"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+}
+
+// DiscoveryDocument 服务端公钥与协议参数
+type DiscoveryDocument struct {
+	IssuedAt          time.Time       `json:"issued_at"`
+	ExpiresAt         time.Time       `json:"expires_at"`
+	Keys              []*DiscoveryKey `json:"keys"`
+	HandshakeVersions []string        `json:"handshake_versions"`
+	FrameVersions     []int           `json:"frame_versions"`
+	Ciphers           []string        `json:"ciphers"`
+	Capabilities      []string        `json:"capabilities"`
+}
+
+type DiscoverySignature struct {
+	Kid       string `json:"kid"`
+	Signature string `json:"signature"`
+}
+
+// DiscoveryResp Document 为序列化后的 DiscoveryDocument 原文
+// 每把公布的密钥各签名一次 客户端使用自己固定的任意一把公钥校验即可
+type DiscoveryResp struct {
+	Document   string                `json:"document"`
+	Signatures []*DiscoverySignature `json:"signatures"`
+}
@@ -0,0 +1,28 @@
+package proto
+
+import "github.com/tangthinker/secret-chat-server/internal/model/schema"
+
+type FriendRequestSendReq struct {
+	UID    string `json:"uid"`
+	Remark string `json:"remark"`
+}
+
+type FriendRequestSendResp struct {
+	Request *schema.FriendRequests `json:"request"`
+}
+
+type FriendRequestHandleReq struct {
+	RequestId uint `json:"request_id"`
+}
+
+type FriendRequestListResp struct {
+	Requests []*schema.FriendRequests `json:"requests"`
+}
+
+type FriendListResp struct {
+	Friends []string `json:"friends"`
+}
+
+type FriendRemoveReq struct {
+	UID string `json:"uid"`
+}
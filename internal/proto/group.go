@@ -0,0 +1,23 @@
+package proto
+
+import "github.com/tangthinker/secret-chat-server/internal/model/schema"
+
+type GroupCreateReq struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type GroupCreateResp struct {
+	Group   *schema.Groups `json:"group"`
+	Members []string       `json:"members"`
+}
+
+type GroupRenameReq struct {
+	GroupId string `json:"group_id"`
+	Name    string `json:"name"`
+}
+
+type GroupMembersReq struct {
+	GroupId string   `json:"group_id"`
+	Members []string `json:"members"`
+}
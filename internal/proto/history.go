@@ -0,0 +1,32 @@
+package proto
+
+import "encoding/json"
+
+type HistoryListReq struct {
+	ConversationId string `json:"conversation_id"`
+	// Before 返回该消息之前的消息 After 返回该消息之后的消息 都为空时返回最新的消息
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Limit  int    `json:"limit"`
+}
+
+type HistoryListResp struct {
+	// Messages 按时间升序排列的完整消息
+	Messages []json.RawMessage `json:"messages"`
+	// HasMore 分页方向上是否还有更多消息
+	HasMore bool `json:"has_more"`
+}
+
+type ConversationSettingsGetReq struct {
+	ConversationId string `json:"conversation_id"`
+}
+
+// ConversationSettingsUpdateReq 只更新请求中提供的字段
+type ConversationSettingsUpdateReq struct {
+	ConversationId   string `json:"conversation_id"`
+	HistoryEnabled   *bool  `json:"history_enabled"`
+	RetentionSeconds *int64 `json:"retention_seconds"`
+	// ExpireSeconds 消息发送后多少秒过期 ExpireAfterReadSeconds 消息被阅读后多少秒过期 0 表示不启用
+	ExpireSeconds          *int64 `json:"expire_seconds"`
+	ExpireAfterReadSeconds *int64 `json:"expire_after_read_seconds"`
+}
@@ -0,0 +1,21 @@
+package proto
+
+import "time"
+
+type PresenceGetReq struct {
+	UIDs []string `json:"uids"`
+}
+
+type PresenceInfo struct {
+	UID      string     `json:"uid"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen"`
+}
+
+type PresenceGetResp struct {
+	Presences []*PresenceInfo `json:"presences"`
+}
+
+type PresenceSettingReq struct {
+	Hidden bool `json:"hidden"`
+}
@@ -0,0 +1,34 @@
+package proto
+
+import "time"
+
+type SessionInfo struct {
+	ConnId      string    `json:"conn_id"`
+	DeviceName  string    `json:"device_name"`
+	DeviceId    string    `json:"device_id,omitempty"`
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+type SessionListResp struct {
+	Sessions []*SessionInfo `json:"sessions"`
+}
+
+type SessionKickReq struct {
+	ConnId string `json:"conn_id"`
+}
+
+type SessionRenameReq struct {
+	ConnId     string `json:"conn_id"`
+	DeviceName string `json:"device_name"`
+}
+
+type SessionKickOthersReq struct {
+	// ConnId 需要保留的当前会话
+	ConnId string `json:"conn_id"`
+}
+
+type SessionKickOthersResp struct {
+	Kicked int `json:"kicked"`
+}
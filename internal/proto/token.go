@@ -0,0 +1,6 @@
+package proto
+
+type TokenRevokeReq struct {
+	UID   string `json:"uid"`
+	Token string `json:"token"`
+}
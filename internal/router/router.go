@@ -4,13 +4,26 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/controller/block"
+	"github.com/tangthinker/secret-chat-server/internal/controller/broadcast"
+	"github.com/tangthinker/secret-chat-server/internal/controller/discovery"
+	"github.com/tangthinker/secret-chat-server/internal/controller/friend"
+	"github.com/tangthinker/secret-chat-server/internal/controller/group"
+	"github.com/tangthinker/secret-chat-server/internal/controller/history"
 	"github.com/tangthinker/secret-chat-server/internal/controller/oss"
+	"github.com/tangthinker/secret-chat-server/internal/controller/presence"
+	"github.com/tangthinker/secret-chat-server/internal/controller/session"
+	"github.com/tangthinker/secret-chat-server/internal/controller/token"
 	"github.com/tangthinker/secret-chat-server/internal/controller/user_info"
 	"github.com/tangthinker/secret-chat-server/internal/controller/ws"
 	"github.com/tangthinker/secret-chat-server/internal/middleware"
 )
 
 func RegisterRouters(router fiber.Router) {
+	// 发现接口无需鉴权 客户端连接前通过它获取公钥与协议参数
+	discoveryCtrl := discovery.New()
+	router.Get("/.well-known/secret-chat", discoveryCtrl.Get)
+
 	rootGroup := router.Group("/api/v1/", middleware.TokenValid, middleware.UserHook)
 
 	rootGroup.Get("/health", func(ctx *fiber.Ctx) error {
@@ -23,7 +36,50 @@ func RegisterRouters(router fiber.Router) {
 	rootGroup.Post("/user/info/exists", userInfoCtrl.Exists)
 
 	websocketCtrl := ws.New()
-	rootGroup.Get("/websocket/conn", websocket.New(websocketCtrl.HandleConn))
+	rootGroup.Get("/websocket/conn", websocketCtrl.SelectKey, websocket.New(websocketCtrl.HandleConn))
+
+	groupCtrl := group.New()
+	rootGroup.Post("/group/create", groupCtrl.Create)
+	rootGroup.Post("/group/rename", groupCtrl.Rename)
+	rootGroup.Post("/group/members/add", groupCtrl.AddMembers)
+	rootGroup.Post("/group/members/remove", groupCtrl.RemoveMembers)
+
+	friendCtrl := friend.New()
+	rootGroup.Post("/friend/request/send", friendCtrl.SendRequest)
+	rootGroup.Post("/friend/request/accept", friendCtrl.AcceptRequest)
+	rootGroup.Post("/friend/request/decline", friendCtrl.DeclineRequest)
+	rootGroup.Post("/friend/request/list", friendCtrl.ListRequests)
+	rootGroup.Post("/friend/list", friendCtrl.List)
+	rootGroup.Post("/friend/remove", friendCtrl.Remove)
+
+	historyCtrl := history.New()
+	rootGroup.Post("/messages/history", historyCtrl.List)
+	rootGroup.Post("/messages/settings/get", historyCtrl.GetSettings)
+	rootGroup.Post("/messages/settings/update", historyCtrl.UpdateSettings)
+
+	tokenCtrl := token.New()
+	rootGroup.Post("/token/revoke", tokenCtrl.Revoke)
+	// 服务间接口 使用共享密钥鉴权 不经过用户 token 校验
+	router.Post("/internal/v1/token/revoke", middleware.ServiceValid, tokenCtrl.RevokeByService)
+
+	sessionCtrl := session.New()
+	rootGroup.Post("/session/list", sessionCtrl.List)
+	rootGroup.Post("/session/kick", sessionCtrl.Kick)
+	rootGroup.Post("/session/rename", sessionCtrl.Rename)
+	rootGroup.Post("/session/kick-others", sessionCtrl.KickOthers)
+
+	presenceCtrl := presence.New()
+	rootGroup.Post("/presence/get", presenceCtrl.Get)
+	rootGroup.Post("/presence/setting/update", presenceCtrl.UpdateSetting)
+
+	blockCtrl := block.New()
+	rootGroup.Post("/block/add", blockCtrl.Block)
+	rootGroup.Post("/block/remove", blockCtrl.Unblock)
+	rootGroup.Post("/block/list", blockCtrl.List)
+
+	broadcastCtrl := broadcast.New()
+	rootGroup.Post("/admin/broadcast", middleware.AdminValid, broadcastCtrl.Send)
+	rootGroup.Post("/admin/broadcast/status", middleware.AdminValid, broadcastCtrl.Status)
 
 	ossCtrl := oss.New()
 	rootGroup.Post("/oss/upload", ossCtrl.Upload)
@@ -0,0 +1,40 @@
+package block
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+)
+
+var ErrInvalidTarget = errors.New("invalid target user")
+
+type Service struct {
+	blocksModel *model.UserBlocksModel
+}
+
+func NewService() *Service {
+	return &Service{
+		blocksModel: model.NewUserBlocksModel(),
+	}
+}
+
+func (s *Service) Block(ctx context.Context, uid string, req *proto.BlockReq) error {
+	if req.UID == uid {
+		return ErrInvalidTarget
+	}
+	return s.blocksModel.Block(ctx, uid, req.UID)
+}
+
+func (s *Service) Unblock(ctx context.Context, uid string, req *proto.BlockReq) error {
+	return s.blocksModel.Unblock(ctx, uid, req.UID)
+}
+
+func (s *Service) List(ctx context.Context, uid string) (*proto.BlockListResp, error) {
+	blocked, err := s.blocksModel.ListBlocked(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.BlockListResp{Blocked: blocked}, nil
+}
@@ -0,0 +1,106 @@
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	defaultDeviceAckTTL = 30 * 24 * time.Hour
+	// defaultDeviceId 未提供 device_id 的旧客户端共用一个确认进度
+	defaultDeviceId = "default"
+)
+
+func (c *Conn) ackDeviceId() string {
+	if c.deviceId == "" {
+		return defaultDeviceId
+	}
+	return c.deviceId
+}
+
+// handleAck 记录当前设备的确认 所有活跃设备都确认后才删除离线消息
+func (ws *WebSocketConnections) handleAck(uid string, connId string, msg *Message) error {
+	if len(msg.Refs) == 0 {
+		return nil
+	}
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return err
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	deviceId := conn.ackDeviceId()
+	if err := ws.messagesModel.MarkAcked(ctx, uid, deviceId, msg.Refs); err != nil {
+		log.Errorf("mark acked messages error: %s", err)
+		return err
+	}
+	if err := ws.deviceAcksModel.Advance(ctx, uid, deviceId, 0); err != nil {
+		log.Errorf("touch device ack error: %s", err)
+	}
+	ws.purgeAcked(ctx, uid, msg.Refs)
+	return nil
+}
+
+// settleDelivered 未协商 ack-seq 的连接发送成功即视为送达
+// 用户没有活跃的确认设备时直接删除 否则按该连接的设备记录确认 等待其他设备确认后删除
+func (ws *WebSocketConnections) settleDelivered(ctx context.Context, uid string, deviceId string, msgIds []string) {
+	if len(msgIds) == 0 {
+		return
+	}
+	devices, err := ws.deviceAcksModel.ListActive(ctx, uid, time.Now().Add(-ws.deviceAckTTL))
+	if err != nil {
+		log.Errorf("list device acks error: %s", err)
+		return
+	}
+	if len(devices) == 0 {
+		if err := ws.messagesModel.DeleteByMsgIds(ctx, uid, msgIds); err != nil {
+			log.Errorf("delete delivered messages error: %s", err)
+		}
+		return
+	}
+	if err := ws.messagesModel.MarkAcked(ctx, uid, deviceId, msgIds); err != nil {
+		log.Errorf("mark delivered messages error: %s", err)
+		return
+	}
+	ws.purgeAcked(ctx, uid, msgIds)
+}
+
+// purgeAcked 删除所有活跃设备都已确认的离线消息 超过 deviceAckTTL 未出现的设备不再阻止删除
+// refs 不为 nil 时只检查这些消息
+func (ws *WebSocketConnections) purgeAcked(ctx context.Context, uid string, refs []string) {
+	devices, err := ws.deviceAcksModel.ListActive(ctx, uid, time.Now().Add(-ws.deviceAckTTL))
+	if err != nil {
+		log.Errorf("list device acks error: %s", err)
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+	if err := ws.messagesModel.DeleteAcked(ctx, uid, devices, refs); err != nil {
+		log.Errorf("delete acked messages error: %s", err)
+	}
+}
+
+// startDeviceAckCleanTask 每天清理长期不活跃设备的确认进度
+func (ws *WebSocketConnections) startDeviceAckCleanTask() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startDeviceAckCleanTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(24 * time.Hour)
+		for range ticker.C {
+			count, err := ws.deviceAcksModel.DeleteInactive(context.Background(), time.Now().Add(-ws.deviceAckTTL))
+			if err != nil {
+				log.Errorf("clean device acks error: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Infof("clean: cleaned %d inactive devices", count)
+			}
+		}
+	}()
+}
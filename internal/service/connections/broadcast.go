@@ -0,0 +1,125 @@
+package connections
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+)
+
+const (
+	// broadcastBatchSize 每批分配序号并写入的用户数
+	broadcastBatchSize    = 500
+	broadcastBatchTimeout = 10 * time.Second
+	// broadcastRetention 广播完成后保留进度的时长
+	broadcastRetention = time.Hour
+)
+
+// BroadcastStatus 广播任务的进度
+type BroadcastStatus struct {
+	Id     string `json:"id"`
+	Total  int    `json:"total"`
+	Sent   int    `json:"sent"`
+	Failed int    `json:"failed"`
+	Done   bool   `json:"done"`
+}
+
+type broadcastJob struct {
+	mutex  sync.Mutex
+	status BroadcastStatus
+}
+
+func (job *broadcastJob) snapshot() *BroadcastStatus {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	status := job.status
+	return &status
+}
+
+func (ws *WebSocketConnections) IsAdmin(uid string) bool {
+	return slices.Contains(ws.admins, uid)
+}
+
+// Broadcast 系统广播 在后台按批为用户分配序号写入离线消息表并推送给在线用户 离线用户在下次连接时同步
+// 立即返回任务进度 完成后调用 onDone
+func (ws *WebSocketConnections) Broadcast(content string, onDone func(*BroadcastStatus)) (*BroadcastStatus, error) {
+	ctx, cal := context.WithTimeout(context.Background(), broadcastBatchTimeout)
+	defer cal()
+	uids, err := ws.userInfoModel.ListUids(ctx)
+	if err != nil {
+		log.Errorf("list uids for broadcast error: %s", err)
+		return nil, err
+	}
+
+	msg := NewMessage(MessageTypeBroadcast, SystemUID, "", content)
+	job := &broadcastJob{status: BroadcastStatus{Id: msg.Id, Total: len(uids)}}
+	ws.broadcasts.Store(msg.Id, job)
+	go ws.runBroadcast(job, msg, uids, onDone)
+	return job.snapshot(), nil
+}
+
+// BroadcastStatusOf 查询广播任务进度 任务不存在或已过期时返回 nil
+func (ws *WebSocketConnections) BroadcastStatusOf(id string) *BroadcastStatus {
+	job, ok := ws.broadcasts.Load(id)
+	if !ok {
+		return nil
+	}
+	return job.(*broadcastJob).snapshot()
+}
+
+func (ws *WebSocketConnections) runBroadcast(job *broadcastJob, msg *Message, uids []string, onDone func(*BroadcastStatus)) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("broadcast error: %v", err)
+		}
+		job.mutex.Lock()
+		job.status.Failed = job.status.Total - job.status.Sent
+		job.status.Done = true
+		job.mutex.Unlock()
+		status := job.snapshot()
+		log.Infof("broadcast finished, id: %s, total: %d, failed: %d", status.Id, status.Total, status.Failed)
+		time.AfterFunc(broadcastRetention, func() {
+			ws.broadcasts.Delete(status.Id)
+		})
+		if onDone != nil {
+			onDone(status)
+		}
+	}()
+
+	for start := 0; start < len(uids); start += broadcastBatchSize {
+		batch := uids[start:min(start+broadcastBatchSize, len(uids))]
+		sent := ws.broadcastBatch(msg, batch)
+		job.mutex.Lock()
+		job.status.Sent += sent
+		job.mutex.Unlock()
+	}
+}
+
+// broadcastBatch 一批用户共用一个事务分配序号 一次写入 返回成功写入的用户数
+func (ws *WebSocketConnections) broadcastBatch(msg *Message, uids []string) int {
+	ctx, cal := context.WithTimeout(context.Background(), broadcastBatchTimeout)
+	defer cal()
+	seqs, err := ws.messagesModel.NextSeqs(ctx, uids)
+	if err != nil {
+		log.Errorf("allocate broadcast seqs error: %s", err)
+		return 0
+	}
+	rows := make([]*schema.Messages, 0, len(uids))
+	for _, uid := range uids {
+		m := *msg
+		m.Seq = seqs[uid]
+		rows = append(rows, &schema.Messages{Uid: uid, Seq: m.Seq, MsgId: m.Id, Content: m.String()})
+	}
+	if err := ws.messagesModel.CreateBatch(ctx, rows); err != nil {
+		log.Errorf("create broadcast messages error: %s", err)
+		return 0
+	}
+	for _, row := range rows {
+		// 离线用户已入库 下次连接时同步
+		_ = ws.send2UserExcept(row.Uid, row.Content, "")
+	}
+	return len(rows)
+}
@@ -0,0 +1,459 @@
+package connections
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/pkg/bus"
+)
+
+const (
+	// subjectRoute 实例上用户上线/下线的路由公告
+	subjectRoute = "cluster.route"
+	// subjectSync 新实例启动时请求其他实例重新公告本地用户
+	subjectSync = "cluster.sync"
+	// subjectRevoke token 注销通知 各实例关闭使用该 token 的连接
+	subjectRevoke = "cluster.revoke"
+	// subjectSession 会话查询与管理请求 持有该用户连接的实例回复到请求方的 subjectReplyPrefix 主题
+	subjectSession = "cluster.session"
+	// subjectReplyPrefix 回复到指定实例的主题前缀
+	subjectReplyPrefix = "cluster.reply."
+	// subjectHeartbeat 实例心跳 超过 routeTTL 未收到心跳的实例路由会被移除
+	subjectHeartbeat = "cluster.heartbeat"
+	// subjectInstancePrefix 投递到指定实例的主题前缀
+	subjectInstancePrefix = "cluster.instance."
+)
+
+const (
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultRouteTTL          = 15 * time.Second
+	// announceBatchSize 重新公告时每条路由消息携带的用户数
+	announceBatchSize = 500
+	// sessionRequestTimeout 等待其他实例回复会话请求的最长时间
+	sessionRequestTimeout = 2 * time.Second
+)
+
+type routeEvent struct {
+	Instance string   `json:"instance"`
+	Uids     []string `json:"uids"`
+	Online   bool     `json:"online"`
+}
+
+// syncEvent Target 为空时所有实例都需要重新公告 否则只有目标实例重新公告
+type syncEvent struct {
+	Instance string `json:"instance"`
+	Target   string `json:"target,omitempty"`
+}
+
+type revokeEvent struct {
+	Instance  string `json:"instance"`
+	Uid       string `json:"uid"`
+	TokenHash string `json:"token_hash"`
+}
+
+// sessionRequest 会话操作请求 Op 为 sessionOpList 等
+type sessionRequest struct {
+	Instance  string `json:"instance"`
+	RequestId string `json:"request_id"`
+	Op        string `json:"op"`
+	Uid       string `json:"uid"`
+	ConnId    string `json:"conn_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// sessionReply Sessions 为列出的会话 Count 为踢出或重命名的会话数
+type sessionReply struct {
+	Instance  string         `json:"instance"`
+	RequestId string         `json:"request_id"`
+	Sessions  []*SessionInfo `json:"sessions,omitempty"`
+	Count     int            `json:"count"`
+}
+
+type heartbeatEvent struct {
+	Instance string `json:"instance"`
+}
+
+type deliverEvent struct {
+	Uid  string `json:"uid"`
+	Data string `json:"data"`
+}
+
+// cluster 多实例路由 记录每个用户的连接分布在哪些实例上 并通过总线转发消息
+type cluster struct {
+	instanceId string
+	bus        bus.Bus
+
+	routeTTL time.Duration
+	// resync 重新公告请求 容量为 1 多次请求合并为一次
+	resync chan struct{}
+
+	mutex  sync.RWMutex
+	routes map[string]map[string]struct{}
+	// lastSeen 其他实例最后一次心跳或公告的时间
+	lastSeen map[string]time.Time
+
+	// pending 等待回复的会话请求
+	pendingMutex sync.Mutex
+	pending      map[string]chan *sessionReply
+}
+
+// newCluster 根据配置创建集群路由 未开启集群时返回 nil
+func newCluster(ws *WebSocketConnections) (*cluster, error) {
+	config := core.GlobalHelper.Config
+	if !config.GetBool("cluster.enabled") {
+		return nil, nil
+	}
+	instanceId := config.GetString("cluster.instance-id")
+	if instanceId == "" {
+		instanceId, _ = os.Hostname()
+	}
+
+	var b bus.Bus
+	switch config.GetString("cluster.mode") {
+	case "tcp":
+		addr := config.GetString("cluster.broker-addr")
+		if config.GetBool("cluster.embed-broker") {
+			if err := bus.NewBroker(addr).Start(); err != nil {
+				return nil, fmt.Errorf("start cluster broker: %w", err)
+			}
+		}
+		tcpBus, err := bus.NewTCPBus(addr)
+		if err != nil {
+			return nil, fmt.Errorf("connect cluster bus: %w", err)
+		}
+		b = tcpBus
+	default:
+		b = bus.NewMemoryBus()
+	}
+
+	heartbeatInterval := config.GetDuration("cluster.heartbeat-interval")
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	routeTTL := config.GetDuration("cluster.route-ttl")
+	if routeTTL <= heartbeatInterval {
+		routeTTL = 3 * heartbeatInterval
+	}
+
+	c, err := startCluster(ws, instanceId, b, routeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("start cluster: %w", err)
+	}
+	c.startHeartbeatTask(heartbeatInterval)
+	log.Infof("cluster enabled, instance id: %s", instanceId)
+	return c, nil
+}
+
+// startCluster 在总线上订阅集群主题 并请求其他实例重新公告路由
+func startCluster(ws *WebSocketConnections, instanceId string, b bus.Bus, routeTTL time.Duration) (*cluster, error) {
+	c := &cluster{
+		instanceId: instanceId,
+		bus:        b,
+		routeTTL:   routeTTL,
+		resync:     make(chan struct{}, 1),
+		routes:     make(map[string]map[string]struct{}),
+		lastSeen:   make(map[string]time.Time),
+		pending:    make(map[string]chan *sessionReply),
+	}
+	subscriptions := map[string]bus.Handler{
+		subjectRoute:     c.handleRoute,
+		subjectSync:      c.handleSync,
+		subjectHeartbeat: c.handleHeartbeat,
+		subjectRevoke: func(subject string, data []byte) {
+			c.handleRevoke(ws, data)
+		},
+		subjectSession: func(subject string, data []byte) {
+			c.handleSessionRequest(ws, data)
+		},
+		subjectReplyPrefix + instanceId: c.handleSessionReply,
+		subjectInstancePrefix + instanceId: func(subject string, data []byte) {
+			c.handleDeliver(ws, data)
+		},
+	}
+	for subject, handler := range subscriptions {
+		if err := b.Subscribe(subject, handler); err != nil {
+			return nil, err
+		}
+	}
+	c.startResyncTask(ws)
+	c.publish(subjectSync, &syncEvent{Instance: instanceId})
+	return c, nil
+}
+
+// announce 公告本实例上用户的上线/下线
+func (c *cluster) announce(uid string, online bool) {
+	c.publish(subjectRoute, &routeEvent{
+		Instance: c.instanceId,
+		Uids:     []string{uid},
+		Online:   online,
+	})
+}
+
+// revoke 通知其他实例关闭使用已注销 token 的连接
+func (c *cluster) revoke(uid string, tokenHash string) {
+	c.publish(subjectRevoke, &revokeEvent{
+		Instance:  c.instanceId,
+		Uid:       uid,
+		TokenHash: tokenHash,
+	})
+}
+
+// announceAll 分批公告本实例上的所有在线用户
+func (c *cluster) announceAll(uids []string) {
+	for start := 0; start < len(uids); start += announceBatchSize {
+		end := start + announceBatchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		c.publish(subjectRoute, &routeEvent{
+			Instance: c.instanceId,
+			Uids:     uids[start:end],
+			Online:   true,
+		})
+	}
+}
+
+// startResyncTask 在独立协程中处理重新公告请求 避免阻塞总线的读协程
+func (c *cluster) startResyncTask(ws *WebSocketConnections) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startResyncTask error: %v", err)
+			}
+		}()
+		for range c.resync {
+			c.announceAll(ws.localUids())
+		}
+	}()
+}
+
+// startHeartbeatTask 定时发送心跳 并移除心跳超时实例的路由
+func (c *cluster) startHeartbeatTask(interval time.Duration) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startHeartbeatTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			c.publish(subjectHeartbeat, &heartbeatEvent{Instance: c.instanceId})
+			c.expireRoutes(time.Now())
+		}
+	}()
+}
+
+// expireRoutes 移除 routeTTL 内没有心跳的实例及其全部路由
+func (c *cluster) expireRoutes(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for instance, seen := range c.lastSeen {
+		if now.Sub(seen) <= c.routeTTL {
+			continue
+		}
+		delete(c.lastSeen, instance)
+		for uid, instances := range c.routes {
+			delete(instances, instance)
+			if len(instances) == 0 {
+				delete(c.routes, uid)
+			}
+		}
+		log.Infof("cluster instance %s heartbeat timeout, routes removed", instance)
+	}
+}
+
+// seen 记录实例的活跃时间 返回该实例此前是否未知
+// 调用方需持有 mutex
+func (c *cluster) seen(instance string) bool {
+	_, known := c.lastSeen[instance]
+	c.lastSeen[instance] = time.Now()
+	return !known
+}
+
+// isRemoteOnline 用户是否在其他实例上有连接
+func (c *cluster) isRemoteOnline(uid string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.routes[uid]) > 0
+}
+
+// remoteInstances 持有该用户连接的其他实例
+func (c *cluster) remoteInstances(uid string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	instances := make([]string, 0, len(c.routes[uid]))
+	for instance := range c.routes[uid] {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+// sendRemote 将消息转发到持有该用户连接的其他实例 至少一个实例发布成功即返回 true
+// 返回 true 只表示已发布到总线 路由可能已过期 不代表对方已收到
+// 需要可靠送达的消息在投递前已写入离线消息表 以库中的记录为准 未送达的会在重连时补发
+func (c *cluster) sendRemote(uid string, message string) bool {
+	instances := c.remoteInstances(uid)
+	sent := false
+	for _, instance := range instances {
+		if c.publish(subjectInstancePrefix+instance, &deliverEvent{Uid: uid, Data: message}) {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// requestSessions 向持有该用户连接的其他实例发出会话请求 并收集回复
+// 在所有实例回复或超时后返回 路由已过期的实例不会回复
+func (c *cluster) requestSessions(req *sessionRequest) []*sessionReply {
+	instances := c.remoteInstances(req.Uid)
+	if len(instances) == 0 {
+		return nil
+	}
+	req.Instance = c.instanceId
+	req.RequestId = uuid.New().String()
+	replies := make(chan *sessionReply, len(instances))
+	c.pendingMutex.Lock()
+	c.pending[req.RequestId] = replies
+	c.pendingMutex.Unlock()
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, req.RequestId)
+		c.pendingMutex.Unlock()
+	}()
+
+	if !c.publish(subjectSession, req) {
+		return nil
+	}
+	result := make([]*sessionReply, 0, len(instances))
+	timeout := time.After(sessionRequestTimeout)
+	for len(result) < len(instances) {
+		select {
+		case reply := <-replies:
+			result = append(result, reply)
+		case <-timeout:
+			log.Infof("cluster session request timeout, op: %s, uid: %s, replies: %d/%d", req.Op, req.Uid, len(result), len(instances))
+			return result
+		}
+	}
+	return result
+}
+
+func (c *cluster) publish(subject string, event interface{}) bool {
+	data, _ := json.Marshal(event)
+	if err := c.bus.Publish(subject, data); err != nil {
+		log.Errorf("cluster publish error, subject: %s, err: %v", subject, err)
+		return false
+	}
+	return true
+}
+
+func (c *cluster) handleRoute(subject string, data []byte) {
+	var event routeEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.Instance == c.instanceId {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seen(event.Instance)
+	for _, uid := range event.Uids {
+		if event.Online {
+			if _, ok := c.routes[uid]; !ok {
+				c.routes[uid] = make(map[string]struct{})
+			}
+			c.routes[uid][event.Instance] = struct{}{}
+			continue
+		}
+		delete(c.routes[uid], event.Instance)
+		if len(c.routes[uid]) == 0 {
+			delete(c.routes, uid)
+		}
+	}
+}
+
+// handleSync 只发出重新公告请求 公告由 startResyncTask 完成
+func (c *cluster) handleSync(subject string, data []byte) {
+	var event syncEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.Instance == c.instanceId {
+		return
+	}
+	if event.Target != "" && event.Target != c.instanceId {
+		return
+	}
+	select {
+	case c.resync <- struct{}{}:
+	default:
+	}
+}
+
+// handleHeartbeat 收到未知实例的心跳时 该实例的路由可能因超时已被移除 请求其重新公告
+func (c *cluster) handleHeartbeat(subject string, data []byte) {
+	var event heartbeatEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.Instance == c.instanceId {
+		return
+	}
+	c.mutex.Lock()
+	unknown := c.seen(event.Instance)
+	c.mutex.Unlock()
+	if unknown {
+		c.publish(subjectSync, &syncEvent{Instance: c.instanceId, Target: event.Instance})
+	}
+}
+
+func (c *cluster) handleDeliver(ws *WebSocketConnections, data []byte) {
+	var event deliverEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+	if err := ws.sendLocal(event.Uid, event.Data, ""); err != nil {
+		log.Infof("deliver cluster message failed, uid: %s, err: %v", event.Uid, err)
+	}
+}
+
+func (c *cluster) handleRevoke(ws *WebSocketConnections, data []byte) {
+	var event revokeEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.Instance == c.instanceId {
+		return
+	}
+	if count := ws.closeRevoked(event.Uid, event.TokenHash); count > 0 {
+		log.Infof("close %d connections of revoked token, uid: %s", count, event.Uid)
+	}
+}
+
+// handleSessionRequest 只有持有该用户连接的实例回复
+func (c *cluster) handleSessionRequest(ws *WebSocketConnections, data []byte) {
+	var req sessionRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.Instance == c.instanceId {
+		return
+	}
+	reply, ok := ws.localSessionOp(&req)
+	if !ok {
+		return
+	}
+	reply.Instance = c.instanceId
+	reply.RequestId = req.RequestId
+	c.publish(subjectReplyPrefix+req.Instance, reply)
+}
+
+func (c *cluster) handleSessionReply(subject string, data []byte) {
+	var reply sessionReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return
+	}
+	c.pendingMutex.Lock()
+	replies, ok := c.pending[reply.RequestId]
+	c.pendingMutex.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case replies <- &reply:
+	default:
+	}
+}
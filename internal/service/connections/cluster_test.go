@@ -0,0 +1,216 @@
+package connections
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+	"github.com/tangthinker/secret-chat-server/pkg/bus"
+)
+
+const testRouteTTL = time.Minute
+
+func newTestInstance(t *testing.T, instanceId string, b bus.Bus) *WebSocketConnections {
+	t.Helper()
+	ws := &WebSocketConnections{connections: make(map[string][]*Conn)}
+	c, err := startCluster(ws, instanceId, b, testRouteTTL)
+	if err != nil {
+		t.Fatalf("start cluster: %v", err)
+	}
+	ws.cluster = c
+	return ws
+}
+
+func newTestConn(connId string, key string) *Conn {
+	c := &Conn{
+		connId:    connId,
+		sendQueue: make(chan outFrame, 16),
+		closed:    make(chan struct{}),
+	}
+	c.SetEncryptKey(key)
+	return c
+}
+
+func addTestConn(ws *WebSocketConnections, uid string, conn *Conn) {
+	ws.mutex.Lock()
+	ws.connections[uid] = append(ws.connections[uid], conn)
+	ws.mutex.Unlock()
+	ws.cluster.announce(uid, true)
+}
+
+func receive(t *testing.T, conn *Conn, key string) string {
+	t.Helper()
+	select {
+	case frame := <-conn.sendQueue:
+		return encrypt.Decrypt(string(frame.data), key)
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+		return ""
+	}
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClusterSendToOwningInstance(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	ws2 := newTestInstance(t, "instance-2", b)
+	ws3 := newTestInstance(t, "instance-3", b)
+
+	key := encrypt.DeriveKey("cluster-test")
+	conn := newTestConn("conn-1", key)
+	addTestConn(ws2, "alice", conn)
+
+	if !ws1.cluster.isRemoteOnline("alice") {
+		t.Fatal("route of alice not learned by instance-1")
+	}
+	if err := ws1.Send2User("alice", "hello"); err != nil {
+		t.Fatalf("send to remote user: %v", err)
+	}
+	if got := receive(t, conn, key); got != "hello" {
+		t.Fatalf("received %q, want %q", got, "hello")
+	}
+	select {
+	case <-conn.sendQueue:
+		t.Fatal("message delivered more than once")
+	default:
+	}
+	if ws3.cluster.isRemoteOnline("bob") {
+		t.Fatal("unexpected route for bob")
+	}
+}
+
+func TestClusterOfflineFallback(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	ws2 := newTestInstance(t, "instance-2", b)
+
+	if err := ws1.Send2User("alice", "hello"); err == nil {
+		t.Fatal("send to user without route should fail")
+	}
+
+	addTestConn(ws2, "alice", newTestConn("conn-1", encrypt.DeriveKey("cluster-test")))
+	if err := ws1.Send2User("alice", "hello"); err != nil {
+		t.Fatalf("send to remote user: %v", err)
+	}
+
+	ws2.cluster.announce("alice", false)
+	if err := ws1.Send2User("alice", "hello"); err == nil {
+		t.Fatal("send to user gone offline should fail")
+	}
+}
+
+func TestClusterExpireRoutes(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	ws2 := newTestInstance(t, "instance-2", b)
+
+	addTestConn(ws2, "alice", newTestConn("conn-1", encrypt.DeriveKey("cluster-test")))
+	ws1.cluster.expireRoutes(time.Now())
+	if !ws1.cluster.isRemoteOnline("alice") {
+		t.Fatal("route removed before ttl")
+	}
+
+	ws1.cluster.expireRoutes(time.Now().Add(2 * testRouteTTL))
+	if ws1.cluster.isRemoteOnline("alice") {
+		t.Fatal("route kept after heartbeat timeout")
+	}
+	if err := ws1.Send2User("alice", "hello"); err == nil {
+		t.Fatal("send to expired route should fail")
+	}
+
+	// 超时实例的心跳恢复后 请求其重新公告
+	ws2.cluster.publish(subjectHeartbeat, &heartbeatEvent{Instance: "instance-2"})
+	eventually(t, func() bool {
+		return ws1.cluster.isRemoteOnline("alice")
+	})
+}
+
+func TestClusterResyncOnStart(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	for i := 0; i < announceBatchSize+10; i++ {
+		uid := fmt.Sprintf("user-%d", i)
+		ws1.connections[uid] = []*Conn{newTestConn(uid, encrypt.DeriveKey("cluster-test"))}
+	}
+
+	ws2 := newTestInstance(t, "instance-2", b)
+	eventually(t, func() bool {
+		ws2.cluster.mutex.RLock()
+		defer ws2.cluster.mutex.RUnlock()
+		return len(ws2.cluster.routes) == len(ws1.connections)
+	})
+}
+
+func TestClusterRevokeToken(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	ws2 := newTestInstance(t, "instance-2", b)
+
+	key := encrypt.DeriveKey("cluster-test")
+	revoked := newTestConn("conn-1", key)
+	revoked.SetToken("token-1")
+	kept := newTestConn("conn-2", key)
+	kept.SetToken("token-2")
+	addTestConn(ws2, "alice", revoked)
+	addTestConn(ws2, "alice", kept)
+
+	if count := ws1.RevokeToken("alice", "token-1"); count != 0 {
+		t.Fatalf("closed %d local connections, want 0", count)
+	}
+	msg, err := ToMessage(receive(t, revoked, key))
+	if err != nil || msg.MessageType != MessageTypeSessionClosed {
+		t.Fatalf("revoked connection not closed: %v", err)
+	}
+	if len(kept.sendQueue) != 0 {
+		t.Fatal("connection with another token closed")
+	}
+}
+
+func TestClusterSessions(t *testing.T) {
+	b := bus.NewMemoryBus()
+	ws1 := newTestInstance(t, "instance-1", b)
+	ws2 := newTestInstance(t, "instance-2", b)
+
+	key := encrypt.DeriveKey("cluster-test")
+	local := newTestConn("conn-1", key)
+	remote := newTestConn("conn-2", key)
+	addTestConn(ws1, "alice", local)
+	addTestConn(ws2, "alice", remote)
+
+	sessions := ws1.ListSessions("alice")
+	if len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(sessions))
+	}
+
+	if !ws1.RenameSession("alice", "conn-2", "laptop") {
+		t.Fatal("remote session not renamed")
+	}
+	if remote.DeviceName() != "laptop" {
+		t.Fatalf("device name %q, want %q", remote.DeviceName(), "laptop")
+	}
+	if ws1.Kick("alice", "conn-3", "test") {
+		t.Fatal("kicked unknown session")
+	}
+
+	if count := ws1.KickOthers("alice", "conn-1", "test"); count != 1 {
+		t.Fatalf("kicked %d sessions, want 1", count)
+	}
+	msg, err := ToMessage(receive(t, remote, key))
+	if err != nil || msg.MessageType != MessageTypeSessionClosed {
+		t.Fatalf("remote session not kicked: %v", err)
+	}
+	if len(local.sendQueue) != 0 {
+		t.Fatal("kept session was kicked")
+	}
+}
@@ -0,0 +1,143 @@
+package connections
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 协商了 compact 能力的连接使用 protobuf 编码的消息 帧头带 frameFlagCompact 标记
+// 对应的 schema:
+//
+//	message Message {
+//	  string id = 1;
+//	  int32 message_type = 2;
+//	  string from = 3;
+//	  string destination = 4;
+//	  string content = 5;
+//	  repeated string refs = 6;
+//	  uint64 seq = 7;
+//	  int64 timestamp = 8; // unix 毫秒
+//	  bool echo = 9;
+//	  int64 expire = 10;
+//	  int64 expire_after_read = 11;
+//	}
+const (
+	compactFieldId              protowire.Number = 1
+	compactFieldMessageType     protowire.Number = 2
+	compactFieldFrom            protowire.Number = 3
+	compactFieldDestination     protowire.Number = 4
+	compactFieldContent         protowire.Number = 5
+	compactFieldRefs            protowire.Number = 6
+	compactFieldSeq             protowire.Number = 7
+	compactFieldTimestamp       protowire.Number = 8
+	compactFieldEcho            protowire.Number = 9
+	compactFieldExpire          protowire.Number = 10
+	compactFieldExpireAfterRead protowire.Number = 11
+)
+
+var ErrCompactMalformed = errors.New("malformed compact message")
+
+func appendCompactString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendCompactVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// encodeCompact 零值字段不写入
+func encodeCompact(msg *Message) []byte {
+	b := make([]byte, 0, len(msg.Content)+64)
+	b = appendCompactString(b, compactFieldId, msg.Id)
+	b = appendCompactVarint(b, compactFieldMessageType, uint64(msg.MessageType))
+	b = appendCompactString(b, compactFieldFrom, msg.From)
+	b = appendCompactString(b, compactFieldDestination, msg.Destination)
+	b = appendCompactString(b, compactFieldContent, msg.Content)
+	for _, ref := range msg.Refs {
+		b = protowire.AppendTag(b, compactFieldRefs, protowire.BytesType)
+		b = protowire.AppendString(b, ref)
+	}
+	b = appendCompactVarint(b, compactFieldSeq, msg.Seq)
+	if !msg.Timestamp.IsZero() {
+		b = appendCompactVarint(b, compactFieldTimestamp, uint64(msg.Timestamp.UnixMilli()))
+	}
+	if msg.Echo {
+		b = appendCompactVarint(b, compactFieldEcho, 1)
+	}
+	b = appendCompactVarint(b, compactFieldExpire, uint64(msg.Expire))
+	b = appendCompactVarint(b, compactFieldExpireAfterRead, uint64(msg.ExpireAfterRead))
+	return b
+}
+
+// decodeCompact 未知字段跳过 便于之后增加字段
+func decodeCompact(b []byte) (*Message, error) {
+	msg := &Message{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, ErrCompactMalformed
+		}
+		b = b[n:]
+		switch {
+		case typ == protowire.BytesType && (num == compactFieldId || num == compactFieldFrom ||
+			num == compactFieldDestination || num == compactFieldContent || num == compactFieldRefs):
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, ErrCompactMalformed
+			}
+			b = b[n:]
+			switch num {
+			case compactFieldId:
+				msg.Id = v
+			case compactFieldFrom:
+				msg.From = v
+			case compactFieldDestination:
+				msg.Destination = v
+			case compactFieldContent:
+				msg.Content = v
+			case compactFieldRefs:
+				msg.Refs = append(msg.Refs, v)
+			}
+		case typ == protowire.VarintType && (num == compactFieldMessageType || num == compactFieldSeq ||
+			num == compactFieldTimestamp || num == compactFieldEcho ||
+			num == compactFieldExpire || num == compactFieldExpireAfterRead):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, ErrCompactMalformed
+			}
+			b = b[n:]
+			switch num {
+			case compactFieldMessageType:
+				msg.MessageType = MessageType(int32(v))
+			case compactFieldSeq:
+				msg.Seq = v
+			case compactFieldTimestamp:
+				msg.Timestamp = time.UnixMilli(int64(v))
+			case compactFieldEcho:
+				msg.Echo = v != 0
+			case compactFieldExpire:
+				msg.Expire = int64(v)
+			case compactFieldExpireAfterRead:
+				msg.ExpireAfterRead = int64(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("%w: field %d", ErrCompactMalformed, num)
+			}
+			b = b[n:]
+		}
+	}
+	return msg, nil
+}
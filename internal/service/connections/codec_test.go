@@ -0,0 +1,89 @@
+package connections
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *Message
+	}{
+		{"empty", &Message{}},
+		{"single", &Message{
+			Id:          "m1",
+			MessageType: MessageTypeSingle,
+			From:        "alice",
+			Destination: "bob",
+			Content:     "hello",
+			Seq:         42,
+			Timestamp:   time.UnixMilli(1700000000123),
+		}},
+		{"refs and flags", &Message{
+			Id:              "m2",
+			MessageType:     MessageTypeAck,
+			Refs:            []string{"a", "b", "c"},
+			Echo:            true,
+			Expire:          1700000000,
+			ExpireAfterRead: 30,
+		}},
+		{"unicode content", &Message{MessageType: MessageTypeGroup, Content: "你好 👋"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeCompact(encodeCompact(c.msg))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.String() != c.msg.String() {
+				t.Fatalf("got %s, want %s", got, c.msg)
+			}
+		})
+	}
+}
+
+func TestDecodeCompactUnknownFields(t *testing.T) {
+	b := encodeCompact(&Message{Id: "m1", Content: "hi"})
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 7)
+	b = protowire.AppendTag(b, 100, protowire.BytesType)
+	b = protowire.AppendString(b, "future")
+	// 已知字段使用了不同的类型时按未知字段跳过
+	b = protowire.AppendTag(b, compactFieldSeq, protowire.BytesType)
+	b = protowire.AppendString(b, "not a varint")
+
+	msg, err := decodeCompact(b)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if msg.Id != "m1" || msg.Content != "hi" || msg.Seq != 0 {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+func TestDecodeCompactMalformed(t *testing.T) {
+	valid := encodeCompact(&Message{Id: "m1", Content: "hello"})
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"string past end", []byte{0x0a, 0x05, 'a'}},
+		{"truncated string", valid[:len(valid)-1]},
+		{"truncated varint", []byte{0x10, 0x80}},
+		{"field number zero", []byte{0x00, 0x01}},
+		{"unterminated group", []byte{0xa3, 0x06}},
+		{"unknown field past end", []byte{0xa2, 0x06, 0x10}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := decodeCompact(c.data); !errors.Is(err, ErrCompactMalformed) {
+				t.Fatalf("got err %v, want %v", err, ErrCompactMalformed)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package connections
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type Connections interface {
@@ -13,11 +16,37 @@ type Connections interface {
 }
 
 type Message struct {
+	// Id 服务端分配的消息id
+	Id          string      `json:"id"`
 	MessageType MessageType `json:"message_type"`
 	From        string      `json:"from"`
 	Destination string      `json:"destination"`
 	Content     string      `json:"content"`
-	Timestamp   time.Time   `json:"timestamp"`
+	// Refs 引用的消息id 用于 ack 等控制消息
+	Refs []string `json:"refs,omitempty"`
+	// Seq 接收方维度单调递增的序号 同步时表示客户端最后收到的序号
+	Seq       uint64    `json:"seq,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Echo 发送方自己在其他设备上发出的消息的同步副本
+	Echo bool `json:"echo,omitempty"`
+	// Expire 发送后多少秒过期 ExpireAfterRead 首次被阅读后多少秒过期 过期后服务端删除并通知在线设备
+	Expire          int64 `json:"expire,omitempty"`
+	ExpireAfterRead int64 `json:"expire_after_read,omitempty"`
+}
+
+func NewMessage(messageType MessageType, from string, destination string, content string) *Message {
+	return &Message{
+		Id:          NewMessageId(),
+		MessageType: messageType,
+		From:        from,
+		Destination: destination,
+		Content:     content,
+		Timestamp:   time.Now(),
+	}
+}
+
+func NewMessageId() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
 }
 
 func (m *Message) String() string {
@@ -39,4 +68,69 @@ const (
 	MessageTypeSingle    MessageType = 1
 	MessageTypeGroup     MessageType = 2
 	MessageTypeBroadcast MessageType = 3
+	// MessageTypeError 服务端返回给发送方的错误帧
+	MessageTypeError MessageType = 4
+	// MessageTypeFriendRequest 好友申请事件 content 为申请记录
+	MessageTypeFriendRequest MessageType = 5
+	// MessageTypeAck 客户端确认已处理的消息 refs 为消息id列表
+	MessageTypeAck MessageType = 6
+	// MessageTypeSync 客户端携带最后收到的 seq 请求补齐缺失的消息
+	MessageTypeSync MessageType = 7
+	// MessageTypeDelivered 送达回执 refs 为原消息id destination 为原消息发送方
+	MessageTypeDelivered MessageType = 8
+	// MessageTypeRead 已读回执 refs 为原消息id destination 为原消息发送方
+	MessageTypeRead MessageType = 9
+	// MessageTypeSignal 瞬时信号 如正在输入 content 为信号类型
+	MessageTypeSignal MessageType = 10
+	// MessageTypePresence 在线状态事件 from 为状态变化的用户 content 为 online/offline
+	MessageTypePresence MessageType = 11
+	// MessageTypeRecall 撤回消息 refs[0] 为被撤回的消息id
+	MessageTypeRecall MessageType = 12
+	// MessageTypeEdit 编辑消息 refs[0] 为被编辑的消息id content 为新内容
+	MessageTypeEdit MessageType = 13
+	// MessageTypeSession 连接建立后下发当前会话信息 content 为 SessionInfo
+	MessageTypeSession MessageType = 14
+	// MessageTypeSessionClosed 服务端主动关闭会话前的通知 content 为 SessionClosed
+	MessageTypeSessionClosed MessageType = 15
+	// MessageTypeTokenRefresh 客户端在连接内更新 token content 为新 token 服务端以同类型回复 ok
+	MessageTypeTokenRefresh MessageType = 16
+	// MessageTypeRekey 会话密钥轮换 content 为 RekeyPayload
+	MessageTypeRekey MessageType = 17
+	// MessageTypeHello 协议协商 content 为 Hello 仅允许作为握手后的第一条消息
+	MessageTypeHello MessageType = 18
+	// MessageTypeExpired 阅后即焚消息已过期 refs 为过期的消息id 客户端收到后删除本地副本
+	// 与撤回一样进入离线队列 离线设备重新连接后也会收到
+	MessageTypeExpired MessageType = 19
 )
+
+// IsEphemeral 瞬时消息只转发给在线连接 从不写入离线消息表
+func (t MessageType) IsEphemeral() bool {
+	switch t {
+	case MessageTypeSignal, MessageTypePresence, MessageTypeSession, MessageTypeSessionClosed, MessageTypeTokenRefresh, MessageTypeRekey, MessageTypeHello:
+		return true
+	}
+	return false
+}
+
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+const (
+	SignalTypingStart    = "typing_start"
+	SignalTypingStop     = "typing_stop"
+	SignalRecordingAudio = "recording_audio"
+	SignalRecordingStop  = "recording_stop"
+)
+
+func IsValidSignal(signal string) bool {
+	switch signal {
+	case SignalTypingStart, SignalTypingStop, SignalRecordingAudio, SignalRecordingStop:
+		return true
+	}
+	return false
+}
+
+// SystemUID 服务端下发消息的发送方
+const SystemUID = "system"
@@ -0,0 +1,99 @@
+package connections
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/tangthinker/secret-chat-server/core"
+)
+
+const (
+	defaultCompressThreshold = 512
+	defaultDecompressMaxSize = 1 << 20
+)
+
+var ErrDecompressTooLarge = errors.New("decompressed frame too large")
+
+// compressor 加密前的应用层 deflate 压缩 密文无法压缩 因此必须在加密前进行
+type compressor struct {
+	enabled   bool
+	level     int
+	threshold int
+	maxSize   int
+
+	writers sync.Pool
+}
+
+var (
+	defaultCompressor     *compressor
+	defaultCompressorOnce sync.Once
+)
+
+func getCompressor() *compressor {
+	defaultCompressorOnce.Do(func() {
+		level := core.GlobalHelper.Config.GetInt("compression.level")
+		if level < flate.HuffmanOnly || level > flate.BestCompression || level == flate.NoCompression {
+			level = flate.DefaultCompression
+		}
+		threshold := core.GlobalHelper.Config.GetInt("compression.threshold")
+		if threshold <= 0 {
+			threshold = defaultCompressThreshold
+		}
+		maxSize := core.GlobalHelper.Config.GetInt("compression.max-size")
+		if maxSize <= 0 {
+			maxSize = defaultDecompressMaxSize
+		}
+		defaultCompressor = &compressor{
+			enabled:   core.GlobalHelper.Config.GetBool("compression.enabled"),
+			level:     level,
+			threshold: threshold,
+			maxSize:   maxSize,
+		}
+	})
+	return defaultCompressor
+}
+
+// compress 小于阈值或压缩后没有变小时返回 false 调用方按原文发送
+func (c *compressor) compress(data []byte) ([]byte, bool) {
+	if len(data) < c.threshold {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&buf, c.level); err != nil {
+			return nil, false
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(data) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress 限制解压后的大小 防止压缩炸弹
+func (c *compressor) decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(c.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > c.maxSize {
+		return nil, ErrDecompressTooLarge
+	}
+	return out, nil
+}
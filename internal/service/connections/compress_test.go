@@ -0,0 +1,83 @@
+package connections
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func newTestCompressor() *compressor {
+	return &compressor{enabled: true, level: flate.DefaultCompression, threshold: 64, maxSize: 1024}
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("deflate: %v", err)
+	}
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func TestCompress(t *testing.T) {
+	random := make([]byte, 512)
+	_, _ = rand.Read(random)
+	cases := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"below threshold", bytes.Repeat([]byte("a"), 63), false},
+		{"incompressible", random, false},
+		{"compressible", bytes.Repeat([]byte(`{"content":"hello"}`), 20), true},
+	}
+	c := newTestCompressor()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed, ok := c.compress(tc.data)
+			if ok != tc.want {
+				t.Fatalf("compressed: %v, want %v", ok, tc.want)
+			}
+			if !ok {
+				return
+			}
+			out, err := c.decompress(compressed)
+			if err != nil || !bytes.Equal(out, tc.data) {
+				t.Fatalf("round trip: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	c := newTestCompressor()
+	cases := []struct {
+		name    string
+		data    []byte
+		wantLen int
+		wantErr error
+	}{
+		{"at max size", deflate(t, make([]byte, 1024)), 1024, nil},
+		{"over max size", deflate(t, make([]byte, 1025)), 0, ErrDecompressTooLarge},
+		{"bomb", deflate(t, make([]byte, 1<<20)), 0, ErrDecompressTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := c.decompress(tc.data)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got err %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && len(out) != tc.wantLen {
+				t.Fatalf("got %d bytes, want %d", len(out), tc.wantLen)
+			}
+		})
+	}
+
+	if _, err := c.decompress([]byte{0xff, 0xff, 0xff}); err == nil {
+		t.Fatal("malformed deflate stream should fail")
+	}
+}
@@ -1,36 +1,217 @@
 package connections
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/google/uuid"
-	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+	"github.com/tangthinker/secret-chat-server/core"
 	skep "github.com/tangthinker/skep-server-go/pkg"
 )
 
 var _ skep.Conn = (*Conn)(nil)
 
+var (
+	ErrConnClosed    = errors.New("connection closed")
+	ErrSendQueueFull = errors.New("send queue full")
+)
+
+// OverflowPolicy 发送队列满时的处理策略
+type OverflowPolicy string
+
+const (
+	// OverflowDrop 丢弃当前帧
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowDisconnect 断开慢连接
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowSpill 丢弃当前帧并写入离线消息表 等待客户端下次同步
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+const (
+	defaultSendQueueSize = 256
+	defaultWriteTimeout  = 10 * time.Second
+	defaultPingInterval  = 30 * time.Second
+	defaultIdleTimeout   = 90 * time.Second
+)
+
+// 自定义关闭码 4000-4999 为应用保留
+const (
+	CloseCodeKicked       = 4001
+	CloseCodeTokenInvalid = 4002
+)
+
+type outFrame struct {
+	messageType int
+	data        []byte
+}
+
 type Conn struct {
-	conn       *websocket.Conn
-	encryptKey string
-	connId     string
+	conn   *websocket.Conn
+	keys   keyState
+	connId string
+
+	// version 与 features 在注册连接前协商确定 之后只读
+	version  int
+	features map[string]bool
+
+	rekeyAfterMessages int
+	rekeyInterval      time.Duration
+	rekeyOverlap       time.Duration
+
+	// deviceName 可通过会话重命名接口修改
+	deviceName atomic.Value
+	// deviceId 客户端提供的稳定设备标识 跨重连不变
+	deviceId    string
+	ip          string
+	connectedAt time.Time
+	token       atomic.Value
+
+	sendQueue      chan outFrame
+	writeTimeout   time.Duration
+	overflowPolicy OverflowPolicy
+	// onSpill 溢出策略为 spill 时处理被丢弃的明文消息
+	onSpill func(data string)
+
+	pingInterval time.Duration
+	idleTimeout  time.Duration
+	// lastActive 最后一次收到客户端帧的时间 unix 纳秒
+	lastActive atomic.Int64
+
+	// sendMutex 保证加密计数顺序与入队顺序一致
+	sendMutex sync.Mutex
+
+	closeOnce  sync.Once
+	closed     chan struct{}
+	writerDone chan struct{}
 }
 
 func NewConn(conn *websocket.Conn) *Conn {
 	connId := uuid.New().String()
-	return &Conn{
+
+	queueSize := core.GlobalHelper.Config.GetInt("websocket.send-queue-size")
+	if queueSize <= 0 {
+		queueSize = defaultSendQueueSize
+	}
+	writeTimeout := core.GlobalHelper.Config.GetDuration("websocket.write-timeout")
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+	pingInterval := core.GlobalHelper.Config.GetDuration("websocket.ping-interval")
+	if pingInterval <= 0 {
+		pingInterval = defaultPingInterval
+	}
+	idleTimeout := core.GlobalHelper.Config.GetDuration("websocket.idle-timeout")
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	rekeyAfterMessages := core.GlobalHelper.Config.GetInt("encrypt-conn.rekey-after-messages")
+	if rekeyAfterMessages <= 0 {
+		rekeyAfterMessages = defaultRekeyAfterMessages
+	}
+	rekeyInterval := core.GlobalHelper.Config.GetDuration("encrypt-conn.rekey-interval")
+	if rekeyInterval <= 0 {
+		rekeyInterval = defaultRekeyInterval
+	}
+	rekeyOverlap := core.GlobalHelper.Config.GetDuration("encrypt-conn.rekey-overlap")
+	if rekeyOverlap <= 0 {
+		rekeyOverlap = defaultRekeyOverlap
+	}
+	policy := OverflowPolicy(core.GlobalHelper.Config.GetString("websocket.overflow-policy"))
+	switch policy {
+	case OverflowDrop, OverflowDisconnect, OverflowSpill:
+	default:
+		policy = OverflowSpill
+	}
+
+	c := &Conn{
 		conn:   conn,
 		connId: connId,
+
+		version:  ProtocolVersion1,
+		features: newFeatureSet(legacyFeatures),
+
+		rekeyAfterMessages: rekeyAfterMessages,
+		rekeyInterval:      rekeyInterval,
+		rekeyOverlap:       rekeyOverlap,
+
+		deviceId:    conn.Query("device_id"),
+		ip:          conn.IP(),
+		connectedAt: time.Now(),
+
+		sendQueue:      make(chan outFrame, queueSize),
+		writeTimeout:   writeTimeout,
+		overflowPolicy: policy,
+
+		pingInterval: pingInterval,
+		idleTimeout:  idleTimeout,
+
+		closed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
 	}
+	c.SetDeviceName(conn.Query("device"))
+	c.touch()
+	conn.SetPongHandler(func(string) error {
+		c.touch()
+		return nil
+	})
+	go c.writeLoop()
+	return c
+}
+
+// touch 记录客户端活跃 并顺延读超时
+func (c *Conn) touch() {
+	now := time.Now()
+	c.lastActive.Store(now.UnixNano())
+	_ = c.conn.SetReadDeadline(now.Add(c.idleTimeout))
+}
+
+// IdleFor 距离最后一次收到客户端帧的时长
+func (c *Conn) IdleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActive.Load()))
 }
 
 func (c *Conn) GetConnId() string {
 	return c.connId
 }
 
+func (c *Conn) Session() *SessionInfo {
+	return &SessionInfo{
+		ConnId:      c.connId,
+		DeviceName:  c.DeviceName(),
+		DeviceId:    c.deviceId,
+		IP:          c.ip,
+		ConnectedAt: c.connectedAt,
+		LastActive:  time.Unix(0, c.lastActive.Load()),
+	}
+}
+
+func (c *Conn) SetDeviceName(name string) {
+	c.deviceName.Store(name)
+}
+
+func (c *Conn) DeviceName() string {
+	name, _ := c.deviceName.Load().(string)
+	return name
+}
+
+func (c *Conn) SetToken(token string) {
+	c.token.Store(token)
+}
+
+func (c *Conn) Token() string {
+	token, _ := c.token.Load().(string)
+	return token
+}
+
 func (c *Conn) SetEncryptKey(encryptKey string) {
-	c.encryptKey = encryptKey
+	c.keys.set(encryptKey)
 }
 
 func (c *Conn) ReadFunc() (string, error) {
@@ -38,6 +219,7 @@ func (c *Conn) ReadFunc() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	c.touch()
 	if messageType != websocket.TextMessage {
 		return "", fmt.Errorf("invalid message type: %d", messageType)
 	}
@@ -45,11 +227,36 @@ func (c *Conn) ReadFunc() (string, error) {
 }
 
 func (c *Conn) WriteFunc(message string) error {
-	return c.conn.WriteMessage(websocket.TextMessage, []byte(message))
+	return c.enqueue(outFrame{messageType: websocket.TextMessage, data: []byte(message)})
 }
 
+// Close 关闭连接 并等待写协程退出 队列中尚未写出的帧会被丢弃
 func (c *Conn) Close() error {
-	return c.conn.Close()
+	err := c.shutdown()
+	<-c.writerDone
+	return err
+}
+
+// CloseWithReason 先发送会话关闭通知 再发送带关闭码的 close 帧 最后关闭连接
+// 发送队列已满时直接关闭
+func (c *Conn) CloseWithReason(code int, reason string) {
+	msg := NewMessage(MessageTypeSessionClosed, SystemUID, "", (&SessionClosed{Code: code, Reason: reason}).String())
+	if err := c.SendMessage(msg.String()); err != nil {
+		_ = c.shutdown()
+		return
+	}
+	if err := c.enqueue(outFrame{messageType: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}); err != nil {
+		_ = c.shutdown()
+	}
+}
+
+func (c *Conn) shutdown() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
 }
 
 func (c *Conn) ReadMessage() (string, error) {
@@ -57,31 +264,229 @@ func (c *Conn) ReadMessage() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if messageType != websocket.TextMessage {
+	c.touch()
+	if messageType == websocket.TextMessage && string(message) == PingFrame {
+		return string(message), nil
+	}
+	if !c.Supports(CapabilityReplayProtection) {
+		return c.readLegacy(messageType, message)
+	}
+	var data []byte
+	switch messageType {
+	case websocket.TextMessage:
+		data, err = hex.DecodeString(string(message))
+		if err != nil {
+			return "", ErrFrameMalformed
+		}
+	case websocket.BinaryMessage:
+		data = message
+	default:
 		return "", fmt.Errorf("invalid message type: %d", messageType)
 	}
-	if string(message) == "PING" {
-		return string(message), nil
+	header, plaintext, err := c.keys.open(data)
+	if err != nil {
+		return "", err
+	}
+	if header.flags&^c.acceptedFlags() != 0 {
+		return "", fmt.Errorf("%w: flags %#x not negotiated", ErrFrameMalformed, header.flags)
+	}
+	if header.flags&frameFlagDeflate != 0 {
+		if plaintext, err = getCompressor().decompress(plaintext); err != nil {
+			return "", fmt.Errorf("decompress frame: %w", err)
+		}
 	}
-	if c.encryptKey == "" {
-		return "", fmt.Errorf("encrypt key is not set")
+	// 客户端可以按帧选择编码 统一转换为 json 交给上层处理
+	if header.flags&frameFlagCompact != 0 {
+		msg, err := decodeCompact(plaintext)
+		if err != nil {
+			return "", err
+		}
+		return msg.String(), nil
 	}
-	decryptedMessage := encrypt.Decrypt(string(message), c.encryptKey)
 
-	return decryptedMessage, nil
+	return string(plaintext), nil
 }
 
+// acceptedFlags 对端可以使用的帧标记 只接受协商过的编码方式
+func (c *Conn) acceptedFlags() byte {
+	var flags byte
+	if c.Supports(CapabilityCompact) {
+		flags |= frameFlagCompact
+	}
+	// 配置关闭压缩时协商阶段不会接受 compression 这里同时校验开关 避免未请求的解压路径
+	if c.Supports(CapabilityCompression) && getCompressor().enabled {
+		flags |= frameFlagDeflate
+	}
+	return flags
+}
+
+// readLegacy 旧格式 文本帧为 hex(随机 nonce || 密文) 二进制帧直接携带其字节
+func (c *Conn) readLegacy(messageType int, message []byte) (string, error) {
+	var data string
+	switch messageType {
+	case websocket.TextMessage:
+		data = string(message)
+	case websocket.BinaryMessage:
+		data = hex.EncodeToString(message)
+	default:
+		return "", fmt.Errorf("invalid message type: %d", messageType)
+	}
+	return c.keys.openLegacy(data)
+}
+
+// SendMessage 加密后放入发送队列 由写协程串行写出
 func (c *Conn) SendMessage(data string) error {
-	if data == "PONG" {
-		return c.conn.WriteMessage(websocket.TextMessage, []byte(data))
+	if data == PongFrame {
+		return c.enqueue(outFrame{messageType: websocket.TextMessage, data: []byte(data)})
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	frame, err := c.sealedFrame(data)
+	if err != nil {
+		return err
+	}
+	err = c.enqueue(frame)
+	if errors.Is(err, ErrSendQueueFull) {
+		c.handleOverflow(data)
 	}
+	return err
+}
 
-	if c.encryptKey == "" {
-		return fmt.Errorf("encrypt key is not set")
+// SendMessageWait 队列已满时最多等待 writeTimeout 不触发溢出策略
+// 用于重连补发 补发量可能超过队列长度 未发出的消息仍保留在库中
+func (c *Conn) SendMessageWait(data string) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	frame, err := c.sealedFrame(data)
+	if err != nil {
+		return err
 	}
+	return c.enqueueWait(frame, c.writeTimeout)
+}
 
-	encryptedMessage := encrypt.Encrypt(data, c.encryptKey)
+func (c *Conn) sealedFrame(data string) (outFrame, error) {
+	sealed, err := c.seal(data)
+	if err != nil {
+		return outFrame{}, err
+	}
+	if c.Supports(CapabilityBinary) {
+		return outFrame{messageType: websocket.BinaryMessage, data: sealed}, nil
+	}
+	return outFrame{messageType: websocket.TextMessage, data: []byte(hex.EncodeToString(sealed))}, nil
+}
 
-	return c.conn.WriteMessage(websocket.TextMessage, []byte(encryptedMessage))
+// seal 默认使用旧格式 协商了 replay-protection 的连接使用带计数的帧格式
+func (c *Conn) seal(data string) ([]byte, error) {
+	if !c.Supports(CapabilityReplayProtection) {
+		sealed, err := c.keys.sealLegacy(data)
+		if err != nil {
+			return nil, err
+		}
+		return hex.DecodeString(sealed)
+	}
+	flags, payload := c.encodePayload(data)
+	return c.keys.seal(flags, payload)
+}
+
+// encodePayload 协商了 compact 的连接使用 protobuf 编码 无法解析为消息时按原文发送
+// 编码方式记录在帧头中 因此只用于带计数的帧格式
+// 协商了 compression 的连接在超过阈值时再进行压缩
+func (c *Conn) encodePayload(data string) (byte, []byte) {
+	var flags byte
+	payload := []byte(data)
+	if c.Supports(CapabilityCompact) {
+		if msg, err := ToMessage(data); err == nil {
+			flags |= frameFlagCompact
+			payload = encodeCompact(msg)
+		}
+	}
+	if c.Supports(CapabilityCompression) {
+		if compressed, ok := getCompressor().compress(payload); ok {
+			flags |= frameFlagDeflate
+			payload = compressed
+		}
+	}
+	return flags, payload
+}
 
+func (c *Conn) enqueue(frame outFrame) error {
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case c.sendQueue <- frame:
+		return nil
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+func (c *Conn) enqueueWait(frame outFrame, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case c.sendQueue <- frame:
+		return nil
+	case <-c.closed:
+		return ErrConnClosed
+	case <-timer.C:
+		return ErrSendQueueFull
+	}
+}
+
+func (c *Conn) handleOverflow(data string) {
+	log.Infof("send queue full, conn id: %s, policy: %s", c.connId, c.overflowPolicy)
+	switch c.overflowPolicy {
+	case OverflowDisconnect:
+		// 关闭底层连接后读循环会返回错误 由 RemoveConnection 完成清理
+		_ = c.shutdown()
+	case OverflowSpill:
+		if c.onSpill != nil {
+			c.onSpill(data)
+		}
+	}
+}
+
+// writeLoop 连接唯一的写协程 websocket 不允许并发写 同时负责定时发送 ping
+func (c *Conn) writeLoop() {
+	defer close(c.writerDone)
+	pingTicker := time.NewTicker(c.pingInterval)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case <-pingTicker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout)); err != nil {
+				log.Infof("write ping failed, conn id: %s, err: %v", c.connId, err)
+				_ = c.shutdown()
+				return
+			}
+		case frame := <-c.sendQueue:
+			if frame.messageType == websocket.CloseMessage {
+				_ = c.conn.WriteControl(websocket.CloseMessage, frame.data, time.Now().Add(c.writeTimeout))
+				_ = c.shutdown()
+				return
+			}
+			if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+				_ = c.shutdown()
+				return
+			}
+			if err := c.conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				log.Infof("write websocket failed, conn id: %s, err: %v", c.connId, err)
+				_ = c.shutdown()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
 }
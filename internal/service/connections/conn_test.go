@@ -0,0 +1,39 @@
+package connections
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+)
+
+func TestSendMessageWaitBypassesOverflow(t *testing.T) {
+	key := encrypt.DeriveKey("conn-test")
+	conn := newTestConn("c1", key)
+	conn.overflowPolicy = OverflowDisconnect
+	conn.writeTimeout = time.Second
+	for i := 0; i < cap(conn.sendQueue); i++ {
+		if err := conn.SendMessageWait("fill"); err != nil {
+			t.Fatalf("fill queue: %v", err)
+		}
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-conn.sendQueue
+	}()
+	if err := conn.SendMessageWait("replay"); err != nil {
+		t.Fatalf("send wait: %v", err)
+	}
+
+	conn.writeTimeout = 10 * time.Millisecond
+	if err := conn.SendMessageWait("replay"); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	select {
+	case <-conn.closed:
+		t.Fatal("replay must not apply the overflow policy")
+	default:
+	}
+}
@@ -0,0 +1,44 @@
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// echo 将发送方发出的消息同步到其其他设备 标记为 echo
+// 不投递给发出消息的连接 离线设备与普通消息一样进入离线队列
+// 发送方没有其他登记过确认进度的设备时只同步在线连接 不写入离线队列
+func (ws *WebSocketConnections) echo(uid string, connId string, msg *Message) {
+	origin, err := ws.getConn(uid, connId)
+	if err != nil {
+		origin = nil
+	}
+	m := *msg
+	m.Echo = true
+	if origin != nil && !ws.hasOtherDevice(uid, origin.ackDeviceId()) {
+		_ = ws.send2UserExcept(uid, m.String(), origin.connId)
+		return
+	}
+	if err := ws.deliverExcept(uid, &m, origin); err != nil {
+		log.Errorf("echo message error, uid: %s, msg id: %s, err: %s", uid, msg.Id, err)
+	}
+}
+
+// hasOtherDevice 用户是否有 deviceId 以外的活跃设备
+func (ws *WebSocketConnections) hasOtherDevice(uid string, deviceId string) bool {
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	devices, err := ws.deviceAcksModel.ListActive(ctx, uid, time.Now().Add(-ws.deviceAckTTL))
+	if err != nil {
+		log.Errorf("list device acks error: %s", err)
+		return true
+	}
+	for _, device := range devices {
+		if device.DeviceId != deviceId {
+			return true
+		}
+	}
+	return false
+}
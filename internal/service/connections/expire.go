@@ -0,0 +1,140 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultExpireSweepInterval = 10 * time.Second
+	expireSweepBatch           = 200
+)
+
+// shorterExpire 取两个过期设置中较短的一个 0 表示不启用
+func shorterExpire(a int64, b int64) int64 {
+	if a <= 0 {
+		return max(b, 0)
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// applyExpiry 消息自带的过期设置不能长于会话的默认设置
+func (ws *WebSocketConnections) applyExpiry(msg *Message, settings *schema.ConversationSettings) {
+	msg.Expire = max(msg.Expire, 0)
+	msg.ExpireAfterRead = max(msg.ExpireAfterRead, 0)
+	if settings == nil {
+		return
+	}
+	msg.Expire = shorterExpire(msg.Expire, settings.ExpireSeconds)
+	msg.ExpireAfterRead = shorterExpire(msg.ExpireAfterRead, settings.ExpireAfterReadSeconds)
+}
+
+// startReadExpiry 收到已读回执后开始阅后即焚计时 只有消息的接收方可以触发 群消息以首次阅读为准
+func (ws *WebSocketConnections) startReadExpiry(uid string, msgIds []string) {
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	for _, msgId := range msgIds {
+		meta, err := ws.metaModel.GetByMsgId(ctx, msgId)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Errorf("get message meta error: %s", err)
+			}
+			continue
+		}
+		if meta.ExpireAfterRead <= 0 || meta.FromUid == uid {
+			continue
+		}
+		if MessageType(meta.MessageType) == MessageTypeGroup {
+			isMember, err := ws.groupsModel.IsMember(ctx, meta.Destination, uid)
+			if err != nil || !isMember {
+				continue
+			}
+		} else if meta.Destination != uid {
+			continue
+		}
+		expireAt := time.Now().Add(time.Duration(meta.ExpireAfterRead) * time.Second)
+		if err := ws.metaModel.SetExpireAt(ctx, msgId, expireAt); err != nil {
+			log.Errorf("set message expire error, msg id: %s, err: %s", msgId, err)
+		}
+	}
+}
+
+// startExpireTask 定期删除过期的阅后即焚消息 并通知在线设备删除本地副本
+func (ws *WebSocketConnections) startExpireTask() {
+	interval := ws.expireSweepInterval
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startExpireTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			ws.expireMessages()
+		}
+	}()
+}
+
+func (ws *WebSocketConnections) expireMessages() {
+	ctx := context.Background()
+	metas, err := ws.metaModel.ListExpired(ctx, time.Now(), expireSweepBatch)
+	if err != nil {
+		log.Errorf("list expired messages error: %v", err)
+		return
+	}
+	count := 0
+	for _, meta := range metas {
+		// 多实例共享数据库时 只有删除成功的实例负责清理和通知
+		deleted, err := ws.metaModel.DeleteByMsgId(ctx, meta.MsgId)
+		if err != nil {
+			log.Errorf("delete expired message meta error: %v", err)
+			continue
+		}
+		if deleted == 0 {
+			continue
+		}
+		ws.expireMessage(ctx, meta)
+		count++
+	}
+	if count > 0 {
+		log.Infof("clean: expired %d messages", count)
+	}
+}
+
+// expireMessage 删除离线队列与历史记录中的消息 并通知发送方与接收方的所有设备
+// 过期通知进入离线队列 已收到原消息但当前离线的设备在重新连接后删除本地副本
+func (ws *WebSocketConnections) expireMessage(ctx context.Context, meta *schema.MessageMeta) {
+	if err := ws.messagesModel.DeleteAllByMsgId(ctx, meta.MsgId); err != nil {
+		log.Errorf("delete expired message error: %s", err)
+	}
+	if err := ws.historyModel.DeleteByMsgId(ctx, meta.MsgId); err != nil {
+		log.Errorf("delete expired history error: %s", err)
+	}
+
+	recipients, err := ws.recipientsOf(ctx, meta)
+	if err != nil {
+		log.Errorf("get message recipients error: %s", err)
+		return
+	}
+	recipients = append(recipients, meta.FromUid)
+	notified := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		if notified[recipient] {
+			continue
+		}
+		notified[recipient] = true
+		msg := NewMessage(MessageTypeExpired, SystemUID, recipient, "")
+		msg.Refs = []string{meta.MsgId}
+		if err := ws.deliver(recipient, msg); err != nil {
+			log.Errorf("deliver expired notice error, uid: %s, err: %s", recipient, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package connections
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// 带计数的加密帧格式 仅用于协商了 replay-protection 的连接 其他连接使用 encrypt.Encrypt 的旧格式
+// header || ciphertext 文本帧中 hex 编码 二进制帧中直接传输
+// header: version(1) | flags(1) | counter(8, 大端)
+// nonce: direction(4, 大端) | counter(8, 大端) 同一密钥下每个方向的计数器严格递增 保证 nonce 不重复
+// header 作为 AEAD 附加数据 篡改 header 会导致解密失败
+const (
+	frameVersion1   byte = 1
+	frameHeaderSize      = 10
+)
+
+// 帧标记 描述明文的编码方式
+const (
+	// frameFlagCompact 明文为 protobuf 编码的消息 未设置时为 json
+	frameFlagCompact byte = 1 << 0
+	// frameFlagDeflate 明文经过 deflate 压缩 解压后再按 frameFlagCompact 解码
+	frameFlagDeflate byte = 1 << 1
+)
+
+// 帧方向 参与 nonce 计算 防止把服务端发出的帧反射回服务端
+const (
+	frameDirectionClient uint32 = 1
+	frameDirectionServer uint32 = 2
+)
+
+var (
+	ErrFrameMalformed = errors.New("malformed frame")
+	ErrFrameReplay    = errors.New("frame replayed or reordered")
+	ErrFrameDecrypt   = errors.New("frame decrypt failed")
+)
+
+type frameHeader struct {
+	version byte
+	flags   byte
+	counter uint64
+}
+
+func (h *frameHeader) bytes() []byte {
+	b := make([]byte, frameHeaderSize)
+	b[0] = h.version
+	b[1] = h.flags
+	binary.BigEndian.PutUint64(b[2:], h.counter)
+	return b
+}
+
+func parseFrameHeader(data []byte) (*frameHeader, error) {
+	if len(data) < frameHeaderSize {
+		return nil, ErrFrameMalformed
+	}
+	h := &frameHeader{
+		version: data[0],
+		flags:   data[1],
+		counter: binary.BigEndian.Uint64(data[2:frameHeaderSize]),
+	}
+	if h.version != frameVersion1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrFrameMalformed, h.version)
+	}
+	return h, nil
+}
+
+func newFrameAEAD(key string) (cipher.AEAD, error) {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil || len(keyBytes) != 32 {
+		return nil, errors.New("invalid encrypt key")
+	}
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func frameNonce(direction uint32, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce, direction)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// sealFrame 加密明文并附加帧头
+func sealFrame(key string, direction uint32, header *frameHeader, plaintext []byte) ([]byte, error) {
+	aead, err := newFrameAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes := header.bytes()
+	return aead.Seal(headerBytes, frameNonce(direction, header.counter), plaintext, headerBytes), nil
+}
+
+// openFrame 校验帧头并解密
+func openFrame(key string, direction uint32, header *frameHeader, data []byte) ([]byte, error) {
+	aead, err := newFrameAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, frameNonce(direction, header.counter), data[frameHeaderSize:], data[:frameHeaderSize])
+	if err != nil {
+		return nil, ErrFrameDecrypt
+	}
+	return plaintext, nil
+}
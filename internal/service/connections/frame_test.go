@@ -0,0 +1,203 @@
+package connections
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+)
+
+// useTestCompressor 测试中不读取配置 固定压缩参数
+func useTestCompressor() *compressor {
+	defaultCompressorOnce.Do(func() {
+		defaultCompressor = &compressor{enabled: true, level: 6, threshold: 64, maxSize: 1024}
+	})
+	return defaultCompressor
+}
+
+type frameStep struct {
+	name      string
+	key       string
+	direction uint32
+	counter   uint64
+	flags     byte
+	mutate    func([]byte) []byte
+	wantErr   error
+}
+
+func TestKeyStateOpen(t *testing.T) {
+	key := encrypt.DeriveKey("frame-test")
+	other := encrypt.DeriveKey("frame-test-other")
+	flip := func(i int) func([]byte) []byte {
+		return func(b []byte) []byte {
+			b[i] ^= 0xff
+			return b
+		}
+	}
+
+	cases := []struct {
+		name  string
+		steps []frameStep
+	}{
+		{"in order", []frameStep{
+			{name: "first", counter: 1},
+			{name: "second", counter: 2},
+			{name: "gap", counter: 10},
+		}},
+		{"replay", []frameStep{
+			{name: "first", counter: 1},
+			{name: "again", counter: 1, wantErr: ErrFrameReplay},
+		}},
+		{"reordered", []frameStep{
+			{name: "later", counter: 3},
+			{name: "earlier", counter: 2, wantErr: ErrFrameReplay},
+			{name: "zero", counter: 0, wantErr: ErrFrameReplay},
+		}},
+		{"tampered ciphertext", []frameStep{
+			{name: "tampered", counter: 1, mutate: flip(frameHeaderSize), wantErr: ErrFrameDecrypt},
+			// 解密失败不推进计数 同一计数的正确帧仍可接受
+			{name: "retry", counter: 1},
+		}},
+		{"tampered header", []frameStep{
+			{name: "flags", counter: 1, mutate: flip(1), wantErr: ErrFrameDecrypt},
+			{name: "counter", counter: 2, mutate: flip(frameHeaderSize - 1), wantErr: ErrFrameDecrypt},
+		}},
+		{"wrong key", []frameStep{
+			{name: "other key", key: other, counter: 1, wantErr: ErrFrameDecrypt},
+		}},
+		{"reflected", []frameStep{
+			{name: "server direction", direction: frameDirectionServer, counter: 1, wantErr: ErrFrameDecrypt},
+		}},
+		{"truncated header", []frameStep{
+			{name: "short", counter: 1, mutate: func(b []byte) []byte { return b[:frameHeaderSize-1] }, wantErr: ErrFrameMalformed},
+			{name: "empty", counter: 1, mutate: func(b []byte) []byte { return nil }, wantErr: ErrFrameMalformed},
+		}},
+		{"unsupported version", []frameStep{
+			{name: "version", counter: 1, mutate: func(b []byte) []byte { b[0] = 2; return b }, wantErr: ErrFrameMalformed},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ks := &keyState{}
+			ks.set(key)
+			for _, step := range c.steps {
+				stepKey, direction := key, frameDirectionClient
+				if step.key != "" {
+					stepKey = step.key
+				}
+				if step.direction != 0 {
+					direction = step.direction
+				}
+				header := &frameHeader{version: frameVersion1, flags: step.flags, counter: step.counter}
+				data, err := sealFrame(stepKey, direction, header, []byte(step.name))
+				if err != nil {
+					t.Fatalf("%s: seal: %v", step.name, err)
+				}
+				if step.mutate != nil {
+					data = step.mutate(data)
+				}
+				got, plaintext, err := ks.open(data)
+				if step.wantErr != nil {
+					if !errors.Is(err, step.wantErr) {
+						t.Fatalf("%s: got err %v, want %v", step.name, err, step.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: open: %v", step.name, err)
+				}
+				if got.counter != step.counter || string(plaintext) != step.name {
+					t.Fatalf("%s: got counter %d plaintext %q", step.name, got.counter, plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyStateSeal(t *testing.T) {
+	key := encrypt.DeriveKey("frame-test")
+	ks := &keyState{}
+	ks.set(key)
+	for i := uint64(1); i <= 3; i++ {
+		data, err := ks.seal(frameFlagCompact, []byte("payload"))
+		if err != nil {
+			t.Fatalf("seal: %v", err)
+		}
+		header, err := parseFrameHeader(data)
+		if err != nil {
+			t.Fatalf("parse header: %v", err)
+		}
+		if header.counter != i || header.flags != frameFlagCompact {
+			t.Fatalf("got counter %d flags %#x, want counter %d", header.counter, header.flags, i)
+		}
+		plaintext, err := openFrame(key, frameDirectionServer, header, data)
+		if err != nil || string(plaintext) != "payload" {
+			t.Fatalf("open sealed frame: %q %v", plaintext, err)
+		}
+	}
+	if _, err := (&keyState{}).seal(0, []byte("payload")); err == nil {
+		t.Fatal("seal without key should fail")
+	}
+}
+
+func TestKeyStatePrevKeyOverlap(t *testing.T) {
+	oldKey := encrypt.DeriveKey("frame-test")
+	cases := []struct {
+		name    string
+		overlap time.Duration
+		wantErr error
+	}{
+		{"within overlap", time.Minute, nil},
+		{"after overlap", -time.Second, ErrFrameDecrypt},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ks := &keyState{}
+			ks.set(oldKey)
+			ks.rotate(encrypt.DeriveKey("shared"), c.overlap)
+
+			// 对端切换前用旧密钥发出的帧
+			header := &frameHeader{version: frameVersion1, counter: 1}
+			data, _ := sealFrame(oldKey, frameDirectionClient, header, []byte("old"))
+			if _, _, err := ks.open(data); !errors.Is(err, c.wantErr) {
+				t.Fatalf("old key frame: got err %v, want %v", err, c.wantErr)
+			}
+
+			header = &frameHeader{version: frameVersion1, counter: 2}
+			data, _ = sealFrame(ks.key, frameDirectionClient, header, []byte("new"))
+			if _, plaintext, err := ks.open(data); err != nil || string(plaintext) != "new" {
+				t.Fatalf("new key frame: %q %v", plaintext, err)
+			}
+		})
+	}
+}
+
+func TestAcceptedFlags(t *testing.T) {
+	comp := useTestCompressor()
+	cases := []struct {
+		name       string
+		features   []string
+		compressOn bool
+		want       byte
+	}{
+		{"none", nil, true, 0},
+		{"compact", []string{CapabilityCompact}, true, frameFlagCompact},
+		{"compression", []string{CapabilityCompression}, true, frameFlagDeflate},
+		{"both", []string{CapabilityCompact, CapabilityCompression}, true, frameFlagCompact | frameFlagDeflate},
+		{"compression disabled", []string{CapabilityCompact, CapabilityCompression}, false, frameFlagCompact},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			enabled := comp.enabled
+			comp.enabled = c.compressOn
+			defer func() { comp.enabled = enabled }()
+
+			conn := &Conn{features: newFeatureSet(c.features)}
+			got := conn.acceptedFlags()
+			if got != c.want {
+				t.Fatalf("got %#x, want %#x", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// handleGroup 群聊消息扇出 在线成员直接投递 离线成员写入离线消息表
+func (ws *WebSocketConnections) handleGroup(uid string, connId string, msg *Message) error {
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+
+	isMember, err := ws.groupsModel.IsMember(ctx, msg.Destination, uid)
+	if err != nil {
+		log.Errorf("check group member error: %s", err)
+		return err
+	}
+	if !isMember {
+		return ws.sendError(uid, connId, "not a member of group: "+msg.Destination)
+	}
+
+	members, err := ws.groupsModel.GetMemberUids(ctx, msg.Destination)
+	if err != nil {
+		log.Errorf("get group members error: %s", err)
+		return err
+	}
+
+	recipients := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != uid {
+			recipients = append(recipients, member)
+		}
+	}
+	ws.record(msg, recipients)
+	for _, member := range recipients {
+		if err := ws.deliver(member, msg); err != nil {
+			log.Errorf("deliver group message error, group: %s, uid: %s, err: %s", msg.Destination, member, err)
+		}
+	}
+	ws.echo(uid, connId, msg)
+	return nil
+}
@@ -0,0 +1,155 @@
+package connections
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// 协议版本
+const (
+	// ProtocolVersion1 未协商的旧客户端 使用 legacyFeatures
+	ProtocolVersion1 = 1
+	// ProtocolVersion2 握手完成后客户端先发送 hello 协商能力
+	ProtocolVersion2 = 2
+
+	maxProtocolVersion = ProtocolVersion2
+)
+
+// 明文心跳帧 不加密
+const (
+	PingFrame = "PING"
+	PongFrame = "PONG"
+)
+
+const defaultHelloTimeout = 5 * time.Second
+
+// legacyFeatures 未协商的连接默认具备的能力 保持旧客户端的行为不变
+// 旧客户端不会发送 ack 因此不包含 ack-seq 消息发送成功即视为送达
+var legacyFeatures = []string{
+	CapabilityReceipts,
+	CapabilitySignal,
+	CapabilityPresence,
+	CapabilityRecallEdit,
+	CapabilityGroup,
+	CapabilityTokenRefresh,
+}
+
+// Hello 客户端声明协议版本与支持的能力 服务端以同类型回复最终接受的版本与能力
+type Hello struct {
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+}
+
+func (h *Hello) String() string {
+	jsData, _ := json.Marshal(h)
+	return string(jsData)
+}
+
+// featureOf 下发该类型消息要求连接具备的能力 空字符串表示不需要
+func featureOf(messageType MessageType) string {
+	switch messageType {
+	case MessageTypeDelivered, MessageTypeRead:
+		return CapabilityReceipts
+	case MessageTypeSignal:
+		return CapabilitySignal
+	case MessageTypePresence:
+		return CapabilityPresence
+	case MessageTypeRecall, MessageTypeEdit:
+		return CapabilityRecallEdit
+	case MessageTypeGroup:
+		return CapabilityGroup
+	}
+	return ""
+}
+
+// messageTypeOf 只解析消息类型
+func messageTypeOf(message string) MessageType {
+	messageType, _ := messageHeaderOf(message)
+	return messageType
+}
+
+// messageHeaderOf 只解析消息类型和消息id
+func messageHeaderOf(message string) (MessageType, string) {
+	var msg struct {
+		Id          string      `json:"id"`
+		MessageType MessageType `json:"message_type"`
+	}
+	_ = json.Unmarshal([]byte(message), &msg)
+	return msg.MessageType, msg.Id
+}
+
+func newFeatureSet(features []string) map[string]bool {
+	set := make(map[string]bool, len(features))
+	for _, feature := range features {
+		set[feature] = true
+	}
+	return set
+}
+
+func (c *Conn) Version() int {
+	return c.version
+}
+
+func (c *Conn) Supports(feature string) bool {
+	return c.features[feature]
+}
+
+// accepts 连接是否接收该类型的消息
+func (c *Conn) accepts(messageType MessageType) bool {
+	feature := featureOf(messageType)
+	return feature == "" || c.Supports(feature)
+}
+
+// Negotiate 读取客户端的 hello 并回复协商结果 需在注册连接前调用
+// 能力取客户端声明与服务端支持的交集 hello 与回复都使用旧格式 之后的帧按协商结果处理
+func (c *Conn) Negotiate(timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultHelloTimeout
+	}
+	// ReadMessage 成功后会恢复为空闲超时
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	message, err := c.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("read hello: %w", err)
+	}
+	msg, err := ToMessage(message)
+	if err != nil || msg.MessageType != MessageTypeHello {
+		return errors.New("first message must be hello")
+	}
+	var hello Hello
+	if err := json.Unmarshal([]byte(msg.Content), &hello); err != nil {
+		return fmt.Errorf("unmarshal hello: %w", err)
+	}
+	if hello.Version < ProtocolVersion2 {
+		return fmt.Errorf("unsupported protocol version: %d", hello.Version)
+	}
+
+	accepted := &Hello{
+		Version:  min(hello.Version, maxProtocolVersion),
+		Features: make([]string, 0, len(hello.Features)),
+	}
+	supported := Capabilities()
+	for _, feature := range hello.Features {
+		if slices.Contains(supported, feature) && !slices.Contains(accepted.Features, feature) {
+			accepted.Features = append(accepted.Features, feature)
+		}
+	}
+	// compact 与 compression 依赖帧头中的标记 只能与带计数的帧格式一起使用
+	if !slices.Contains(accepted.Features, CapabilityReplayProtection) {
+		accepted.Features = slices.DeleteFunc(accepted.Features, func(feature string) bool {
+			return feature == CapabilityCompact || feature == CapabilityCompression
+		})
+	}
+
+	// 回复仍使用协商前的格式 发送后协商结果才生效
+	reply := NewMessage(MessageTypeHello, SystemUID, "", accepted.String())
+	if err := c.SendMessage(reply.String()); err != nil {
+		return err
+	}
+	c.version = accepted.Version
+	c.features = newFeatureSet(accepted.Features)
+	return nil
+}
@@ -0,0 +1,136 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+)
+
+// 会话 id 单聊为 s:<len(uid)>:<uid><uid> 两个 uid 按字典序排列 以长度前缀区分 uid 中可以包含任意字符
+// 群聊为 g:<group_id>
+const (
+	conversationPrefixSingle = "s:"
+	conversationPrefixGroup  = "g:"
+)
+
+func SingleConversationId(uid string, peer string) string {
+	if uid > peer {
+		uid, peer = peer, uid
+	}
+	return conversationPrefixSingle + strconv.Itoa(len(uid)) + ":" + uid + peer
+}
+
+func GroupConversationId(groupId string) string {
+	return conversationPrefixGroup + groupId
+}
+
+// ParseConversationId 解析会话 id 单聊返回两个 uid 群聊返回群 id
+func ParseConversationId(conversationId string) (uids []string, groupId string, ok bool) {
+	switch {
+	case strings.HasPrefix(conversationId, conversationPrefixSingle):
+		length, rest, found := strings.Cut(strings.TrimPrefix(conversationId, conversationPrefixSingle), ":")
+		if !found {
+			return nil, "", false
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil || n <= 0 || n >= len(rest) {
+			return nil, "", false
+		}
+		uid, peer := rest[:n], rest[n:]
+		// 只接受规范形式 同一会话只有一个 id
+		if SingleConversationId(uid, peer) != conversationId {
+			return nil, "", false
+		}
+		return []string{uid, peer}, "", true
+	case strings.HasPrefix(conversationId, conversationPrefixGroup):
+		groupId = strings.TrimPrefix(conversationId, conversationPrefixGroup)
+		return nil, groupId, groupId != ""
+	}
+	return nil, "", false
+}
+
+// conversationIdOf 只有单聊和群聊消息属于会话
+func conversationIdOf(msg *Message) string {
+	switch msg.MessageType {
+	case MessageTypeSingle:
+		return SingleConversationId(msg.From, msg.Destination)
+	case MessageTypeGroup:
+		return GroupConversationId(msg.Destination)
+	}
+	return ""
+}
+
+// record 按会话设置补全过期时间 并记录消息元信息与历史记录 需在投递前调用
+// recipients 为本次投递的接收方 不含发送方
+func (ws *WebSocketConnections) record(msg *Message, recipients []string) {
+	settings := ws.conversationSettings(msg)
+	ws.applyExpiry(msg, settings)
+	ws.recordMeta(msg, recipients)
+	ws.recordHistory(msg, settings)
+}
+
+// conversationSettings 会话没有设置或查询失败时返回 nil
+func (ws *WebSocketConnections) conversationSettings(msg *Message) *schema.ConversationSettings {
+	conversationId := conversationIdOf(msg)
+	if conversationId == "" {
+		return nil
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	settings, err := ws.settingsModel.Get(ctx, conversationId)
+	if err != nil {
+		log.Errorf("get conversation settings error, conversation id: %s, err: %s", conversationId, err)
+		return nil
+	}
+	return settings
+}
+
+// recordHistory 会话开启了历史记录时写入消息日志
+func (ws *WebSocketConnections) recordHistory(msg *Message, settings *schema.ConversationSettings) {
+	if settings == nil || !settings.HistoryEnabled {
+		return
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	err := ws.historyModel.Create(ctx, &schema.MessageHistory{
+		ConversationId: settings.ConversationId,
+		MsgId:          msg.Id,
+		FromUid:        msg.From,
+		Content:        msg.String(),
+	})
+	if err != nil {
+		log.Errorf("create message history error, msg id: %s, err: %s", msg.Id, err)
+	}
+}
+
+// updateHistory 撤回时删除历史消息 编辑时替换历史消息的内容
+func (ws *WebSocketConnections) updateHistory(ctx context.Context, msg *Message) {
+	msgId := msg.Refs[0]
+	if msg.MessageType == MessageTypeRecall {
+		if err := ws.historyModel.DeleteByMsgId(ctx, msgId); err != nil {
+			log.Errorf("delete recalled history error: %s", err)
+		}
+		return
+	}
+	history, err := ws.historyModel.GetByMsgId(ctx, msgId)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Errorf("get message history error: %s", err)
+		}
+		return
+	}
+	original, err := ToMessage(history.Content)
+	if err != nil {
+		return
+	}
+	original.Content = msg.Content
+	if err := ws.historyModel.UpdateContent(ctx, msgId, original.String()); err != nil {
+		log.Errorf("update edited history error: %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+package connections
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSingleConversationId(t *testing.T) {
+	cases := []struct {
+		uid, peer string
+	}{
+		{"alice", "bob"},
+		{"bob", "alice"},
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{"12", "3"},
+		{"same", "same"},
+	}
+	for _, c := range cases {
+		id := SingleConversationId(c.uid, c.peer)
+		if id != SingleConversationId(c.peer, c.uid) {
+			t.Fatalf("id of %q/%q depends on order", c.uid, c.peer)
+		}
+		uids, groupId, ok := ParseConversationId(id)
+		if !ok || groupId != "" {
+			t.Fatalf("parse %q failed", id)
+		}
+		want := []string{c.uid, c.peer}
+		slices.Sort(want)
+		if !slices.Equal(uids, want) {
+			t.Fatalf("parse %q = %v, want %v", id, uids, want)
+		}
+	}
+	if SingleConversationId("a:b", "c") == SingleConversationId("a", "b:c") {
+		t.Fatal("distinct pairs share an id")
+	}
+}
+
+func TestParseConversationIdInvalid(t *testing.T) {
+	for _, id := range []string{"", "s:", "s:x:ab", "s:0:ab", "s:2:ab", "s:-1:ab", "s:1:ba", "s:alice:bob", "g:", "x:1"} {
+		if _, _, ok := ParseConversationId(id); ok {
+			t.Fatalf("%q should be invalid", id)
+		}
+	}
+	if _, groupId, ok := ParseConversationId("g:42"); !ok || groupId != "42" {
+		t.Fatal("group id not parsed")
+	}
+}
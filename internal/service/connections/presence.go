@@ -0,0 +1,81 @@
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// IsOnline 用户在本实例或其他实例上是否有连接
+func (ws *WebSocketConnections) IsOnline(uid string) bool {
+	ws.mutex.RLock()
+	online := len(ws.connections[uid]) > 0
+	ws.mutex.RUnlock()
+	return online || (ws.cluster != nil && ws.cluster.isRemoteOnline(uid))
+}
+
+// onOnline 用户建立第一个连接
+func (ws *WebSocketConnections) onOnline(uid string) {
+	ws.publishPresence(uid, PresenceOnline)
+}
+
+// onOffline 用户最后一个连接断开
+func (ws *WebSocketConnections) onOffline(uid string) {
+	ws.publishPresence(uid, PresenceOffline)
+}
+
+// OnPresenceHidden 在线用户切换隐藏状态 隐藏时向好友推送离线 取消隐藏时推送在线
+func (ws *WebSocketConnections) OnPresenceHidden(uid string, hidden bool) {
+	if !ws.IsOnline(uid) {
+		return
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	status := PresenceOnline
+	if hidden {
+		status = PresenceOffline
+	}
+	ws.notifyFriends(ctx, uid, status)
+}
+
+// publishPresence 记录最后在线时间 并向在线好友推送状态变化 隐藏状态的用户不推送
+func (ws *WebSocketConnections) publishPresence(uid string, status string) {
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+
+	if err := ws.presenceModel.UpdateLastSeen(ctx, uid, time.Now()); err != nil {
+		log.Errorf("update last seen error, uid: %s, err: %s", uid, err)
+	}
+
+	hidden, err := ws.presenceModel.IsHidden(ctx, uid)
+	if err != nil {
+		log.Errorf("get presence setting error, uid: %s, err: %s", uid, err)
+		return
+	}
+	if hidden {
+		return
+	}
+	ws.notifyFriends(ctx, uid, status)
+}
+
+// notifyFriends 向好友推送状态 与 uid 互有屏蔽的好友不推送
+func (ws *WebSocketConnections) notifyFriends(ctx context.Context, uid string, status string) {
+	friends, err := ws.relationsModel.ListFriends(ctx, uid)
+	if err != nil {
+		log.Errorf("list friends for presence error, uid: %s, err: %s", uid, err)
+		return
+	}
+	blocked, err := ws.blocksModel.ListBlockedEither(ctx, uid)
+	if err != nil {
+		log.Errorf("list blocks for presence error, uid: %s, err: %s", uid, err)
+		return
+	}
+	for _, friend := range friends {
+		if blocked[friend] {
+			continue
+		}
+		msg := NewMessage(MessageTypePresence, uid, friend, status)
+		_ = ws.Send2User(friend, msg.String())
+	}
+}
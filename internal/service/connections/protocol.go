@@ -0,0 +1,60 @@
+package connections
+
+// 握手与加密协议版本 由发现接口对外公布
+const (
+	HandshakeVersionSkep1 = "skep-1"
+	CipherAES256GCM       = "aes-256-gcm"
+)
+
+// 服务端能力
+const (
+	CapabilityAckSeq           = "ack-seq"
+	CapabilityReceipts         = "receipts"
+	CapabilitySignal           = "signal"
+	CapabilityPresence         = "presence"
+	CapabilityRecallEdit       = "recall-edit"
+	CapabilityGroup            = "group"
+	CapabilityTokenRefresh     = "token-refresh"
+	CapabilityRekey            = "rekey"
+	CapabilityReplayProtection = "replay-protection"
+	CapabilityKeyring          = "keyring"
+	// CapabilityBinary 服务端使用二进制帧直接发送加密字节
+	CapabilityBinary = "binary"
+	// CapabilityCompact 服务端使用 protobuf 编码消息
+	CapabilityCompact = "compact"
+	// CapabilityCompression 超过阈值的消息在加密前使用 deflate 压缩 需在配置中开启
+	CapabilityCompression = "compression"
+)
+
+func HandshakeVersions() []string {
+	return []string{HandshakeVersionSkep1}
+}
+
+func FrameVersions() []int {
+	return []int{int(frameVersion1)}
+}
+
+func Ciphers() []string {
+	return []string{CipherAES256GCM}
+}
+
+func Capabilities() []string {
+	capabilities := []string{
+		CapabilityAckSeq,
+		CapabilityReceipts,
+		CapabilitySignal,
+		CapabilityPresence,
+		CapabilityRecallEdit,
+		CapabilityGroup,
+		CapabilityTokenRefresh,
+		CapabilityRekey,
+		CapabilityReplayProtection,
+		CapabilityKeyring,
+		CapabilityBinary,
+		CapabilityCompact,
+	}
+	if getCompressor().enabled {
+		capabilities = append(capabilities, CapabilityCompression)
+	}
+	return capabilities
+}
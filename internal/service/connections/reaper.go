@@ -0,0 +1,57 @@
+package connections
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/core"
+)
+
+// startReapTask 定期移除超过空闲时间的连接 兜底处理读循环未能及时感知的半开连接
+func (ws *WebSocketConnections) startReapTask() {
+	interval := core.GlobalHelper.Config.GetDuration("websocket.ping-interval")
+	if interval <= 0 {
+		interval = defaultPingInterval
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startReapTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			ws.reap()
+		}
+	}()
+}
+
+func (ws *WebSocketConnections) reap() {
+	type deadConn struct {
+		uid    string
+		connId string
+	}
+	dead := make([]deadConn, 0)
+	alive := make([]*Conn, 0)
+	ws.mutex.RLock()
+	for uid, conns := range ws.connections {
+		for _, conn := range conns {
+			if conn.IdleFor() > conn.idleTimeout {
+				dead = append(dead, deadConn{uid: uid, connId: conn.connId})
+				continue
+			}
+			alive = append(alive, conn)
+		}
+	}
+	ws.mutex.RUnlock()
+
+	// 空闲连接没有消息驱动 在这里检查基于时间的密钥轮换
+	for _, conn := range alive {
+		ws.maybeRekey(conn)
+	}
+
+	for _, conn := range dead {
+		log.Infof("reap idle connection, uid: %s, conn id: %s", conn.uid, conn.connId)
+		ws.RemoveConnection(conn.uid, conn.connId)
+	}
+}
@@ -0,0 +1,129 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultEditWindow    = 2 * time.Minute
+	defaultMetaRetention = 7 * 24 * time.Hour
+)
+
+// recordMeta 记录消息元信息 供撤回、编辑和回执时校验
+func (ws *WebSocketConnections) recordMeta(msg *Message, recipients []string) {
+	meta := &schema.MessageMeta{
+		MsgId:           msg.Id,
+		FromUid:         msg.From,
+		Destination:     msg.Destination,
+		MessageType:     int(msg.MessageType),
+		Recipients:      strings.Join(recipients, ","),
+		ExpireAfterRead: msg.ExpireAfterRead,
+	}
+	if msg.Expire > 0 {
+		expireAt := msg.Timestamp.Add(time.Duration(msg.Expire) * time.Second)
+		meta.ExpireAt = &expireAt
+	}
+	err := ws.metaModel.Create(context.Background(), meta)
+	if err != nil {
+		log.Errorf("create message meta error, msg id: %s, err: %s", msg.Id, err)
+	}
+}
+
+// handleRecallEdit 处理撤回与编辑 仅原发送方可在时间窗口内操作
+// 撤回会删除尚在离线队列中的原消息 控制消息本身推送给接收方的所有设备 群消息推送给发送时的所有成员
+func (ws *WebSocketConnections) handleRecallEdit(uid string, connId string, msg *Message) error {
+	if len(msg.Refs) != 1 {
+		return ws.sendError(uid, connId, "invalid control message: exactly one ref is required")
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+
+	meta, err := ws.metaModel.GetByMsgId(ctx, msg.Refs[0])
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ws.sendError(uid, connId, "message not found or edit window expired: "+msg.Refs[0])
+		}
+		log.Errorf("get message meta error: %s", err)
+		return err
+	}
+	if meta.FromUid != uid {
+		return ws.sendError(uid, connId, "permission denied: not the sender of message "+msg.Refs[0])
+	}
+	if time.Since(meta.CreatedAt) > ws.editWindow {
+		return ws.sendError(uid, connId, "edit window expired: "+msg.Refs[0])
+	}
+	msg.Destination = meta.Destination
+
+	recipients, err := ws.recipientsOf(ctx, meta)
+	if err != nil {
+		log.Errorf("get message recipients error: %s", err)
+		return err
+	}
+
+	if msg.MessageType == MessageTypeRecall {
+		if err := ws.messagesModel.DeleteAllByMsgId(ctx, meta.MsgId); err != nil {
+			log.Errorf("delete recalled message error: %s", err)
+		}
+	}
+	ws.updateHistory(ctx, msg)
+	for _, recipient := range recipients {
+		if err := ws.deliver(recipient, msg); err != nil {
+			log.Errorf("deliver control message error, uid: %s, err: %s", recipient, err)
+		}
+	}
+	if meta.Destination != uid {
+		ws.echo(uid, connId, msg)
+	}
+	return nil
+}
+
+// startMetaCleanTask 定期清理超出保留时长的消息元信息 元信息同时用于校验回执 保留时长不短于编辑窗口
+// 阅后即焚消息的元信息由过期任务清理
+func (ws *WebSocketConnections) startMetaCleanTask() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startMetaCleanTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(ws.editWindow)
+		for range ticker.C {
+			count, err := ws.metaModel.DeleteBefore(context.Background(), time.Now().Add(-ws.metaRetention))
+			if err != nil {
+				log.Errorf("clean message meta error: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Infof("clean: cleaned %d message meta", count)
+			}
+		}
+	}()
+}
+
+// recipientsOf 消息发送时的接收方 不含发送方 旧记录没有接收方时按当前群成员计算
+func (ws *WebSocketConnections) recipientsOf(ctx context.Context, meta *schema.MessageMeta) ([]string, error) {
+	if recipients := meta.RecipientList(); recipients != nil {
+		return recipients, nil
+	}
+	if MessageType(meta.MessageType) != MessageTypeGroup {
+		return []string{meta.Destination}, nil
+	}
+	members, err := ws.groupsModel.GetMemberUids(ctx, meta.Destination)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != meta.FromUid {
+			recipients = append(recipients, member)
+		}
+	}
+	return recipients, nil
+}
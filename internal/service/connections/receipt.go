@@ -0,0 +1,60 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+// handleReceipt 回执路由回原消息发送方 对方离线时与普通消息一样进入离线队列
+// refs 必须都是 destination 发给当前用户或当前用户所在群的消息 被原发送方屏蔽时不投递
+func (ws *WebSocketConnections) handleReceipt(uid string, connId string, msg *Message) error {
+	if msg.Destination == "" || len(msg.Refs) == 0 {
+		return ws.sendError(uid, connId, "invalid receipt: destination and refs are required")
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+
+	blocked, err := ws.blocksModel.IsBlocked(ctx, msg.Destination, uid)
+	if err != nil {
+		log.Errorf("check blocked error: %s", err)
+		return err
+	}
+	if blocked {
+		return ws.sendError(uid, connId, "message rejected by recipient: "+msg.Destination)
+	}
+
+	for _, ref := range msg.Refs {
+		meta, err := ws.metaModel.GetByMsgId(ctx, ref)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ws.sendError(uid, connId, "message not found: "+ref)
+			}
+			log.Errorf("get message meta error: %s", err)
+			return err
+		}
+		if meta.FromUid != msg.Destination {
+			return ws.sendError(uid, connId, "permission denied: receipt for message "+ref)
+		}
+		if MessageType(meta.MessageType) == MessageTypeGroup {
+			isMember, err := ws.groupsModel.IsMember(ctx, meta.Destination, uid)
+			if err != nil {
+				log.Errorf("check group member error: %s", err)
+				return err
+			}
+			if !isMember {
+				return ws.sendError(uid, connId, "permission denied: receipt for message "+ref)
+			}
+		} else if meta.Destination != uid {
+			return ws.sendError(uid, connId, "permission denied: receipt for message "+ref)
+		}
+	}
+
+	if msg.MessageType == MessageTypeRead {
+		ws.startReadExpiry(uid, msg.Refs)
+	}
+	return ws.deliver(msg.Destination, msg)
+}
@@ -0,0 +1,264 @@
+package connections
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+)
+
+const (
+	defaultRekeyAfterMessages = 1000
+	defaultRekeyInterval      = 30 * time.Minute
+	defaultRekeyOverlap       = 10 * time.Second
+)
+
+const (
+	RekeyPhaseInit  = "init"
+	RekeyPhaseReply = "reply"
+)
+
+// RekeyPayload 密钥轮换消息内容 双方交换临时 ECDH 公钥
+// 新密钥 = DeriveKey(ECDH(临时私钥, 对端临时公钥), 旧密钥)
+type RekeyPayload struct {
+	Phase string `json:"phase"`
+	Pub   string `json:"pub"`
+}
+
+func (p *RekeyPayload) String() string {
+	jsData, _ := json.Marshal(p)
+	return string(jsData)
+}
+
+// keyState 连接的会话密钥状态
+// 轮换后旧密钥在重叠窗口内仍可用于解密 以兼容对端切换前已发出的帧
+type keyState struct {
+	mutex sync.RWMutex
+
+	key           string
+	prevKey       string
+	prevKeyExpire time.Time
+
+	// pendingPriv 本端发起轮换时生成的临时私钥 收到对端 reply 后使用
+	pendingPriv string
+	pendingAt   time.Time
+
+	messages  int
+	rotatedAt time.Time
+
+	// 帧计数器 轮换密钥时不重置 sendCounter 为最后发出的计数 recvCounter 为最后接受的计数
+	sendCounter uint64
+	recvCounter uint64
+}
+
+func (ks *keyState) set(key string) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.key = key
+	ks.rotatedAt = time.Now()
+	ks.messages = 0
+}
+
+// sealLegacy 旧格式 hex(随机 nonce || 密文) 未协商 replay-protection 的连接使用
+func (ks *keyState) sealLegacy(plaintext string) (string, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if ks.key == "" {
+		return "", errors.New("encrypt key is not set")
+	}
+	data := encrypt.Encrypt(plaintext, ks.key)
+	if data == "" {
+		return "", errors.New("encrypt failed")
+	}
+	ks.messages++
+	return data, nil
+}
+
+// openLegacy 解密旧格式的帧
+func (ks *keyState) openLegacy(data string) (string, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if ks.key == "" {
+		return "", errors.New("encrypt key is not set")
+	}
+	keys := []string{ks.key}
+	if ks.prevKey != "" && time.Now().Before(ks.prevKeyExpire) {
+		keys = append(keys, ks.prevKey)
+	}
+	for _, key := range keys {
+		if plaintext := encrypt.Decrypt(data, key); plaintext != "" {
+			ks.messages++
+			return plaintext, nil
+		}
+	}
+	return "", ErrFrameDecrypt
+}
+
+// seal 使用当前密钥和下一个发送计数加密
+func (ks *keyState) seal(flags byte, plaintext []byte) ([]byte, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if ks.key == "" {
+		return nil, errors.New("encrypt key is not set")
+	}
+	header := &frameHeader{version: frameVersion1, flags: flags, counter: ks.sendCounter + 1}
+	data, err := sealFrame(ks.key, frameDirectionServer, header, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ks.sendCounter++
+	ks.messages++
+	return data, nil
+}
+
+// open 拒绝计数不大于已接受计数的帧 解密成功后才推进计数
+// 轮换后的重叠窗口内 对端切换前发出的帧仍使用旧密钥
+func (ks *keyState) open(data []byte) (*frameHeader, []byte, error) {
+	header, err := parseFrameHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if ks.key == "" {
+		return nil, nil, errors.New("encrypt key is not set")
+	}
+	if header.counter <= ks.recvCounter {
+		return nil, nil, fmt.Errorf("%w: counter %d, last %d", ErrFrameReplay, header.counter, ks.recvCounter)
+	}
+	keys := []string{ks.key}
+	if ks.prevKey != "" && time.Now().Before(ks.prevKeyExpire) {
+		keys = append(keys, ks.prevKey)
+	}
+	for _, key := range keys {
+		plaintext, err := openFrame(key, frameDirectionClient, header, data)
+		if err != nil {
+			continue
+		}
+		ks.recvCounter = header.counter
+		ks.messages++
+		return header, plaintext, nil
+	}
+	return nil, nil, ErrFrameDecrypt
+}
+
+// rotate 切换到由共享密钥与旧密钥派生的新密钥 调用方需持有 mutex
+func (ks *keyState) rotate(sharedKey string, overlap time.Duration) {
+	ks.prevKey = ks.key
+	ks.prevKeyExpire = time.Now().Add(overlap)
+	ks.key = encrypt.DeriveKey(sharedKey, ks.key)
+	ks.pendingPriv = ""
+	ks.messages = 0
+	ks.rotatedAt = time.Now()
+}
+
+// NeedRekey 达到消息数量或时间阈值 且当前没有进行中的轮换
+func (c *Conn) NeedRekey() bool {
+	c.keys.mutex.RLock()
+	defer c.keys.mutex.RUnlock()
+	if c.keys.key == "" {
+		return false
+	}
+	// 对端长时间未回复时允许重新发起
+	if c.keys.pendingPriv != "" && time.Since(c.keys.pendingAt) < c.rekeyInterval {
+		return false
+	}
+	return c.keys.messages >= c.rekeyAfterMessages || time.Since(c.keys.rotatedAt) >= c.rekeyInterval
+}
+
+// StartRekey 服务端发起密钥轮换 在收到对端 reply 之前继续使用旧密钥
+func (c *Conn) StartRekey() error {
+	pub, priv := encrypt.GenerateKeyPairECDH()
+	if pub == "" {
+		return errors.New("generate ecdh key pair failed")
+	}
+	c.keys.mutex.Lock()
+	c.keys.pendingPriv = priv
+	c.keys.pendingAt = time.Now()
+	c.keys.mutex.Unlock()
+
+	msg := NewMessage(MessageTypeRekey, SystemUID, "", (&RekeyPayload{Phase: RekeyPhaseInit, Pub: pub}).String())
+	return c.SendMessage(msg.String())
+}
+
+// pendingRekey 本端发起的轮换尚在等待回复 超过 rekeyInterval 未回复的视为已放弃
+func (c *Conn) pendingRekey() bool {
+	c.keys.mutex.RLock()
+	defer c.keys.mutex.RUnlock()
+	return c.keys.pendingPriv != "" && time.Since(c.keys.pendingAt) < c.rekeyInterval
+}
+
+// HandleRekey 处理对端的轮换消息
+// 双方同时发起时以服务端为准 服务端忽略对端的 init 客户端收到服务端的 init 时放弃自己的轮换并回复
+func (c *Conn) HandleRekey(payload *RekeyPayload) error {
+	switch payload.Phase {
+	case RekeyPhaseInit:
+		if c.pendingRekey() {
+			log.Debugf("ignore peer rekey init while own rekey is pending, conn id: %s", c.connId)
+			return nil
+		}
+		// 对端发起 使用旧密钥回复后立即切换
+		pub, priv := encrypt.GenerateKeyPairECDH()
+		if pub == "" {
+			return errors.New("generate ecdh key pair failed")
+		}
+		sharedKey, err := encrypt.GenerateSharedKey(priv, payload.Pub)
+		if err != nil {
+			return fmt.Errorf("generate shared key: %w", err)
+		}
+		msg := NewMessage(MessageTypeRekey, SystemUID, "", (&RekeyPayload{Phase: RekeyPhaseReply, Pub: pub}).String())
+		if err := c.SendMessage(msg.String()); err != nil {
+			return err
+		}
+		c.keys.mutex.Lock()
+		c.keys.rotate(sharedKey, c.rekeyOverlap)
+		c.keys.mutex.Unlock()
+	case RekeyPhaseReply:
+		c.keys.mutex.Lock()
+		defer c.keys.mutex.Unlock()
+		if c.keys.pendingPriv == "" {
+			return errors.New("no pending rekey")
+		}
+		sharedKey, err := encrypt.GenerateSharedKey(c.keys.pendingPriv, payload.Pub)
+		if err != nil {
+			c.keys.pendingPriv = ""
+			return fmt.Errorf("generate shared key: %w", err)
+		}
+		c.keys.rotate(sharedKey, c.rekeyOverlap)
+	default:
+		return fmt.Errorf("invalid rekey phase: %s", payload.Phase)
+	}
+	log.Debugf("session key rotated, conn id: %s", c.connId)
+	return nil
+}
+
+func (ws *WebSocketConnections) handleRekey(uid string, connId string, msg *Message) error {
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return err
+	}
+	var payload RekeyPayload
+	if err := json.Unmarshal([]byte(msg.Content), &payload); err != nil {
+		return ws.sendError(uid, connId, "invalid rekey message")
+	}
+	if err := conn.HandleRekey(&payload); err != nil {
+		log.Infof("rekey failed, uid: %s, conn id: %s, err: %v", uid, connId, err)
+		return ws.sendError(uid, connId, "rekey failed: "+err.Error())
+	}
+	return nil
+}
+
+// maybeRekey 达到阈值时由服务端发起轮换
+// 只对协商了 rekey 能力的连接发起 旧客户端无法回复
+func (ws *WebSocketConnections) maybeRekey(conn *Conn) {
+	if !conn.Supports(CapabilityRekey) || !conn.NeedRekey() {
+		return
+	}
+	if err := conn.StartRekey(); err != nil {
+		log.Infof("start rekey failed, conn id: %s, err: %v", conn.connId, err)
+	}
+}
@@ -0,0 +1,61 @@
+package connections
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+)
+
+func TestRekeyServerInitWins(t *testing.T) {
+	key := encrypt.DeriveKey("rekey-test")
+	conn := newTestConn("conn-1", key)
+	conn.rekeyInterval = time.Minute
+	conn.rekeyOverlap = time.Second
+
+	if err := conn.StartRekey(); err != nil {
+		t.Fatalf("start rekey: %v", err)
+	}
+	msg, err := ToMessage(receive(t, conn, key))
+	if err != nil {
+		t.Fatalf("parse rekey init: %v", err)
+	}
+	var serverInit RekeyPayload
+	if err := json.Unmarshal([]byte(msg.Content), &serverInit); err != nil || serverInit.Phase != RekeyPhaseInit {
+		t.Fatalf("unexpected rekey message: %s", msg.Content)
+	}
+
+	// 客户端同时发起的 init 被忽略 不回复也不切换密钥
+	clientPub, _ := encrypt.GenerateKeyPairECDH()
+	if err := conn.HandleRekey(&RekeyPayload{Phase: RekeyPhaseInit, Pub: clientPub}); err != nil {
+		t.Fatalf("handle concurrent init: %v", err)
+	}
+	if len(conn.sendQueue) != 0 {
+		t.Fatal("server replied to concurrent client init")
+	}
+	if conn.keys.key != key {
+		t.Fatal("key rotated by concurrent client init")
+	}
+
+	// 客户端放弃自己的轮换 回复服务端的 init 双方得到相同的新密钥
+	replyPub, replyPriv := encrypt.GenerateKeyPairECDH()
+	sharedKey, err := encrypt.GenerateSharedKey(replyPriv, serverInit.Pub)
+	if err != nil {
+		t.Fatalf("client shared key: %v", err)
+	}
+	if err := conn.HandleRekey(&RekeyPayload{Phase: RekeyPhaseReply, Pub: replyPub}); err != nil {
+		t.Fatalf("handle reply: %v", err)
+	}
+	if want := encrypt.DeriveKey(sharedKey, key); conn.keys.key != want {
+		t.Fatal("server and client derived different keys")
+	}
+
+	// 轮换完成后对端的 init 正常处理
+	if err := conn.HandleRekey(&RekeyPayload{Phase: RekeyPhaseInit, Pub: clientPub}); err != nil {
+		t.Fatalf("handle init: %v", err)
+	}
+	if len(conn.sendQueue) != 1 {
+		t.Fatal("server did not reply to client init")
+	}
+}
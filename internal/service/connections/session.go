@@ -0,0 +1,160 @@
+package connections
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type SessionInfo struct {
+	ConnId      string    `json:"conn_id"`
+	DeviceName  string    `json:"device_name"`
+	DeviceId    string    `json:"device_id,omitempty"`
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+func (s *SessionInfo) String() string {
+	jsData, _ := json.Marshal(s)
+	return string(jsData)
+}
+
+// SessionClosed 服务端主动关闭会话的原因
+type SessionClosed struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (s *SessionClosed) String() string {
+	jsData, _ := json.Marshal(s)
+	return string(jsData)
+}
+
+// 集群内的会话操作
+const (
+	sessionOpList       = "list"
+	sessionOpKick       = "kick"
+	sessionOpKickOthers = "kick-others"
+	sessionOpRename     = "rename"
+)
+
+// ListSessions 列出用户在所有实例上的会话
+func (ws *WebSocketConnections) ListSessions(uid string) []*SessionInfo {
+	sessions := ws.localSessions(uid)
+	for _, reply := range ws.remoteSessionOp(&sessionRequest{Op: sessionOpList, Uid: uid}) {
+		sessions = append(sessions, reply.Sessions...)
+	}
+	return sessions
+}
+
+// Kick 断开指定会话 返回是否找到该会话
+func (ws *WebSocketConnections) Kick(uid string, connId string, reason string) bool {
+	if ws.kickLocal(uid, connId, reason) {
+		return true
+	}
+	req := &sessionRequest{Op: sessionOpKick, Uid: uid, ConnId: connId, Reason: reason}
+	return sumCount(ws.remoteSessionOp(req)) > 0
+}
+
+// KickOthers 断开除 keepConnId 之外的所有会话 返回断开的数量
+func (ws *WebSocketConnections) KickOthers(uid string, keepConnId string, reason string) int {
+	count := ws.kickOthersLocal(uid, keepConnId, reason)
+	req := &sessionRequest{Op: sessionOpKickOthers, Uid: uid, ConnId: keepConnId, Reason: reason}
+	return count + sumCount(ws.remoteSessionOp(req))
+}
+
+// RenameSession 修改会话的设备名称 返回是否找到该会话
+func (ws *WebSocketConnections) RenameSession(uid string, connId string, name string) bool {
+	if ws.renameLocal(uid, connId, name) {
+		return true
+	}
+	req := &sessionRequest{Op: sessionOpRename, Uid: uid, ConnId: connId, Name: name}
+	return sumCount(ws.remoteSessionOp(req)) > 0
+}
+
+func (ws *WebSocketConnections) remoteSessionOp(req *sessionRequest) []*sessionReply {
+	if ws.cluster == nil {
+		return nil
+	}
+	return ws.cluster.requestSessions(req)
+}
+
+func sumCount(replies []*sessionReply) int {
+	count := 0
+	for _, reply := range replies {
+		count += reply.Count
+	}
+	return count
+}
+
+// localSessionOp 在本实例上执行其他实例发来的会话请求 本实例没有该用户的连接时返回 false
+func (ws *WebSocketConnections) localSessionOp(req *sessionRequest) (*sessionReply, bool) {
+	ws.mutex.RLock()
+	_, ok := ws.connections[req.Uid]
+	ws.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	reply := &sessionReply{}
+	switch req.Op {
+	case sessionOpList:
+		reply.Sessions = ws.localSessions(req.Uid)
+	case sessionOpKick:
+		if ws.kickLocal(req.Uid, req.ConnId, req.Reason) {
+			reply.Count = 1
+		}
+	case sessionOpKickOthers:
+		reply.Count = ws.kickOthersLocal(req.Uid, req.ConnId, req.Reason)
+	case sessionOpRename:
+		if ws.renameLocal(req.Uid, req.ConnId, req.Name) {
+			reply.Count = 1
+		}
+	default:
+		return nil, false
+	}
+	return reply, true
+}
+
+func (ws *WebSocketConnections) localSessions(uid string) []*SessionInfo {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	sessions := make([]*SessionInfo, 0, len(ws.connections[uid]))
+	for _, conn := range ws.connections[uid] {
+		sessions = append(sessions, conn.Session())
+	}
+	return sessions
+}
+
+func (ws *WebSocketConnections) kickLocal(uid string, connId string, reason string) bool {
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return false
+	}
+	conn.CloseWithReason(CloseCodeKicked, reason)
+	return true
+}
+
+func (ws *WebSocketConnections) renameLocal(uid string, connId string, name string) bool {
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return false
+	}
+	conn.SetDeviceName(name)
+	return true
+}
+
+func (ws *WebSocketConnections) kickOthersLocal(uid string, keepConnId string, reason string) int {
+	ws.mutex.RLock()
+	targets := make([]*Conn, 0, len(ws.connections[uid]))
+	for _, conn := range ws.connections[uid] {
+		if conn.connId != keepConnId {
+			targets = append(targets, conn)
+		}
+	}
+	ws.mutex.RUnlock()
+
+	for _, conn := range targets {
+		conn.CloseWithReason(CloseCodeKicked, reason)
+	}
+	return len(targets)
+}
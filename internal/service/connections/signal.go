@@ -0,0 +1,26 @@
+package connections
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// handleSignal 转发瞬时信号 接收方不在线或已屏蔽发送方时直接丢弃
+func (ws *WebSocketConnections) handleSignal(uid string, connId string, msg *Message) error {
+	if !IsValidSignal(msg.Content) {
+		return ws.sendError(uid, connId, "invalid signal: "+msg.Content)
+	}
+	blocked, err := ws.blocksModel.IsBlocked(context.Background(), msg.Destination, uid)
+	if err != nil {
+		log.Errorf("check blocked error: %s", err)
+		return err
+	}
+	if blocked {
+		return nil
+	}
+	if err := ws.Send2User(msg.Destination, msg.String()); err != nil {
+		log.Debugf("drop signal to offline user, uid: %s, signal: %s", msg.Destination, msg.Content)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package connections
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/service/token"
+)
+
+const defaultTokenRevalidateInterval = 5 * time.Minute
+
+// handleTokenRefresh 连接内更新 token 新 token 必须属于同一用户
+func (ws *WebSocketConnections) handleTokenRefresh(uid string, connId string, msg *Message) error {
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return err
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	tokenUid, err := ws.tokenService.Verify(ctx, msg.Content)
+	if err != nil || tokenUid != uid {
+		return ws.sendError(uid, connId, "refresh token failed: invalid token")
+	}
+	conn.SetToken(msg.Content)
+	reply := NewMessage(MessageTypeTokenRefresh, SystemUID, uid, "ok")
+	reply.Refs = []string{msg.Id}
+	return conn.SendMessage(reply.String())
+}
+
+// RevokeToken 立即关闭所有使用该 token 的连接 返回本实例关闭的连接数
+// 其他实例上的连接通过集群总线通知关闭
+func (ws *WebSocketConnections) RevokeToken(uid string, tk string) int {
+	tokenHash := token.Hash(tk)
+	if ws.cluster != nil {
+		ws.cluster.revoke(uid, tokenHash)
+	}
+	return ws.closeRevoked(uid, tokenHash)
+}
+
+// closeRevoked 关闭本实例上该用户使用摘要为 tokenHash 的 token 的连接
+func (ws *WebSocketConnections) closeRevoked(uid string, tokenHash string) int {
+	ws.mutex.RLock()
+	targets := make([]*Conn, 0)
+	for _, conn := range ws.connections[uid] {
+		if token.Hash(conn.Token()) == tokenHash {
+			targets = append(targets, conn)
+		}
+	}
+	ws.mutex.RUnlock()
+
+	for _, conn := range targets {
+		conn.CloseWithReason(CloseCodeTokenInvalid, "token revoked")
+	}
+	return len(targets)
+}
+
+// startTokenCheckTask 定期重新校验长连接的 token 过期或被注销的连接会被关闭
+func (ws *WebSocketConnections) startTokenCheckTask() {
+	interval := core.GlobalHelper.Config.GetDuration("websocket.token-revalidate-interval")
+	if interval <= 0 {
+		interval = defaultTokenRevalidateInterval
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startTokenCheckTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			ws.checkTokens()
+		}
+	}()
+}
+
+func (ws *WebSocketConnections) checkTokens() {
+	type target struct {
+		uid  string
+		conn *Conn
+	}
+	targets := make([]target, 0)
+	ws.mutex.RLock()
+	for uid, conns := range ws.connections {
+		for _, conn := range conns {
+			targets = append(targets, target{uid: uid, conn: conn})
+		}
+	}
+	ws.mutex.RUnlock()
+
+	for _, t := range targets {
+		ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+		tokenUid, err := ws.tokenService.Verify(ctx, t.conn.Token())
+		cal()
+		if err == nil && tokenUid == t.uid {
+			continue
+		}
+		log.Infof("token invalid, close connection, uid: %s, conn id: %s, err: %v", t.uid, t.conn.connId, err)
+		t.conn.CloseWithReason(CloseCodeTokenInvalid, "token expired or revoked")
+	}
+}
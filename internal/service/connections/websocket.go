@@ -4,83 +4,278 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/core"
 	"github.com/tangthinker/secret-chat-server/internal/model"
 	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"github.com/tangthinker/secret-chat-server/internal/service/token"
 )
 
 type WebSocketConnections struct {
 	connections map[string][]*Conn
 	mutex       sync.RWMutex
 
-	messagesModel *model.MessagesModel
+	// deliverLocks 按接收方串行化序号分配与投递 保证单个连接上的投递顺序与序号一致
+	// 按 uid 哈希分段 固定数量 不随用户增长 持有期间不会再获取其他用户的锁
+	deliverLocks [deliverLockStripes]sync.Mutex
+
+	// cluster 多实例路由 未开启集群时为 nil
+	cluster *cluster
+
+	// broadcasts 进行中与最近完成的广播任务
+	broadcasts sync.Map
+
+	admins              []string
+	editWindow          time.Duration
+	expireSweepInterval time.Duration
+	metaRetention       time.Duration
+	deviceAckTTL        time.Duration
+
+	messagesModel   *model.MessagesModel
+	deviceAcksModel *model.DeviceAcksModel
+	groupsModel     *model.GroupsModel
+	userInfoModel   *model.UserInfoModel
+	blocksModel     *model.UserBlocksModel
+	relationsModel  *model.UserRelationsModel
+	presenceModel   *model.UserPresenceModel
+	metaModel       *model.MessageMetaModel
+	historyModel    *model.MessageHistoryModel
+	settingsModel   *model.ConversationSettingsModel
+
+	tokenService *token.Service
+}
+
+const deliverLockStripes = 256
+
+var (
+	defaultConnections     *WebSocketConnections
+	defaultConnectionsErr  error
+	defaultConnectionsOnce sync.Once
+)
+
+// Init 创建进程内共享的连接管理器 需在注册路由前调用 返回错误时应终止启动
+func Init() error {
+	defaultConnectionsOnce.Do(func() {
+		defaultConnections, defaultConnectionsErr = NewWebSocketConnections()
+	})
+	return defaultConnectionsErr
+}
+
+// Default 返回进程内共享的连接管理器 供 websocket 与 REST 接口共同使用 Init 失败时返回 nil
+func Default() *WebSocketConnections {
+	_ = Init()
+	return defaultConnections
 }
 
-func NewWebSocketConnections() *WebSocketConnections {
-	return &WebSocketConnections{
+func NewWebSocketConnections() (*WebSocketConnections, error) {
+	editWindow := core.GlobalHelper.Config.GetDuration("message.edit-window")
+	if editWindow <= 0 {
+		editWindow = defaultEditWindow
+	}
+	metaRetention := core.GlobalHelper.Config.GetDuration("message.meta-retention")
+	if metaRetention <= 0 {
+		metaRetention = defaultMetaRetention
+	}
+	if metaRetention < editWindow {
+		metaRetention = editWindow
+	}
+	deviceAckTTL := core.GlobalHelper.Config.GetDuration("message.device-ack-ttl")
+	if deviceAckTTL <= 0 {
+		deviceAckTTL = defaultDeviceAckTTL
+	}
+	expireSweepInterval := core.GlobalHelper.Config.GetDuration("message.expire-sweep-interval")
+	if expireSweepInterval <= 0 {
+		expireSweepInterval = defaultExpireSweepInterval
+	}
+	ws := &WebSocketConnections{
 		connections: make(map[string][]*Conn),
 		mutex:       sync.RWMutex{},
 
-		messagesModel: model.NewMessagesModel(),
+		admins:              core.GlobalHelper.Config.GetStringSlice("admin.uids"),
+		editWindow:          editWindow,
+		expireSweepInterval: expireSweepInterval,
+		metaRetention:       metaRetention,
+		deviceAckTTL:        deviceAckTTL,
+
+		messagesModel:   model.NewMessagesModel(),
+		deviceAcksModel: model.NewDeviceAcksModel(),
+		groupsModel:     model.NewGroupsModel(),
+		userInfoModel:   model.NewUserInfoModel(),
+		blocksModel:     model.NewUserBlocksModel(),
+		relationsModel:  model.NewUserRelationsModel(),
+		presenceModel:   model.NewUserPresenceModel(),
+		metaModel:       model.NewMessageMetaModel(),
+		historyModel:    model.NewMessageHistoryModel(),
+		settingsModel:   model.NewConversationSettingsModel(),
+
+		tokenService: token.Default(),
+	}
+	c, err := newCluster(ws)
+	if err != nil {
+		return nil, err
 	}
+	ws.cluster = c
+	ws.startMetaCleanTask()
+	ws.startExpireTask()
+	ws.startDeviceAckCleanTask()
+	ws.startReapTask()
+	ws.startTokenCheckTask()
+	return ws, nil
 }
 
-func (ws *WebSocketConnections) AddConnection(uid string, conn *Conn) {
-	ws.mutex.Lock()
-	if _, ok := ws.connections[uid]; ok {
-		ws.connections[uid] = append(ws.connections[uid], conn)
-		ws.mutex.Unlock()
-		return
+// AddConnection 注册连接 并补发序号大于 lastSeq 的未确认消息 lastSeq 为 0 时补发全部
+func (ws *WebSocketConnections) AddConnection(uid string, conn *Conn, lastSeq uint64) {
+	conn.onSpill = func(data string) {
+		ws.spill(uid, data)
 	}
-	ws.connections[uid] = []*Conn{conn}
+
+	// 注册与补发期间持有投递锁 新消息只能排在补发之后 且不会既实时投递又被补发
+	unlock := ws.lockDeliver(uid)
+	ws.mutex.Lock()
+	first := len(ws.connections[uid]) == 0
+	ws.connections[uid] = append(ws.connections[uid], conn)
 	ws.mutex.Unlock()
 
+	session := NewMessage(MessageTypeSession, SystemUID, uid, conn.Session().String())
+	if err := conn.SendMessage(session.String()); err != nil {
+		log.Infof("send session info failed, uid: %s, err: %v", uid, err)
+	}
+	ws.replay(uid, conn, lastSeq)
+	unlock()
+
+	if first {
+		online := ws.cluster != nil && ws.cluster.isRemoteOnline(uid)
+		if ws.cluster != nil {
+			ws.cluster.announce(uid, true)
+		}
+		if !online {
+			ws.onOnline(uid)
+		}
+	}
+}
+
+// replay 向连接补发离线消息 调用方需持有该用户的投递锁
+// lastSeq 视为该设备对之前消息的确认 带消息id的记录在所有活跃设备确认之后才删除
+func (ws *WebSocketConnections) replay(uid string, conn *Conn, lastSeq uint64) {
 	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cal()
-	msgs, err := ws.messagesModel.GetListByUid(ctx, uid)
+	deviceId := conn.ackDeviceId()
+	// 只有协商了 ack-seq 的设备才登记确认进度 否则其不发送的 ack 会让消息永远无法删除
+	ackSeq := conn.Supports(CapabilityAckSeq)
+	if ackSeq {
+		if err := ws.deviceAcksModel.Advance(ctx, uid, deviceId, lastSeq); err != nil {
+			log.Errorf("advance device ack error: %s", err)
+		}
+		ws.purgeAcked(ctx, uid, nil)
+	}
+	msgs, err := ws.messagesModel.GetListByUidAfterSeq(ctx, uid, lastSeq)
 	if err != nil {
 		return
 	}
 	msgIds := make([]uint, 0)
+	delivered := make([]string, 0)
 	for _, msg := range msgs {
-		err = conn.SendMessage(msg.Content)
-		if err != nil {
-			log.Infof("write message to websocket fail, uid: %s, msg: %s", uid, msg.Content)
+		// 本设备已确认的消息 连接不支持的消息 以及本设备发出消息的同步副本不下发
+		if msg.MsgId != "" && msg.AckedBy(deviceId, lastSeq) {
+			continue
+		}
+		if !conn.accepts(messageTypeOf(msg.Content)) || (msg.ExcludeDevice != "" && msg.ExcludeDevice == deviceId) {
 			continue
 		}
-		msgIds = append(msgIds, msg.ID)
+		// 补发不走溢出策略 队列持续满时停止补发 剩余消息留待下次重连
+		err = conn.SendMessageWait(msg.Content)
+		if err != nil {
+			log.Infof("replay to websocket stopped, uid: %s, conn id: %s, err: %v", uid, conn.connId, err)
+			break
+		}
+		if msg.MsgId == "" {
+			msgIds = append(msgIds, msg.ID)
+		} else if !ackSeq {
+			delivered = append(delivered, msg.MsgId)
+		}
 	}
 	if len(msgIds) > 0 {
 		if err := ws.messagesModel.Delete(context.Background(), msgIds); err != nil {
 			log.Infof("delete synced messages error: %s", err)
 		}
 	}
+	ws.settleDelivered(context.Background(), uid, deviceId, delivered)
 }
 
 func (ws *WebSocketConnections) RemoveConnection(uid string, connId string) {
+	if ws.removeConn(uid, connId) {
+		if ws.cluster != nil {
+			ws.cluster.announce(uid, false)
+		}
+		if !ws.IsOnline(uid) {
+			ws.onOffline(uid)
+		}
+	}
+}
+
+// removeConn 移除并关闭连接 返回该用户是否已没有任何连接
+// Close 会等待写协程退出 因此在释放锁之后执行
+func (ws *WebSocketConnections) removeConn(uid string, connId string) bool {
+	conn, empty := ws.detachConn(uid, connId)
+	if conn == nil {
+		return false
+	}
+	conn.Close()
+	return empty
+}
+
+func (ws *WebSocketConnections) detachConn(uid string, connId string) (*Conn, bool) {
 	ws.mutex.Lock()
 	defer ws.mutex.Unlock()
-	conns, ok := ws.connections[uid]
-	if !ok {
-		return
-	}
+	conns := ws.connections[uid]
 	for i, conn := range conns {
-		if conn.connId == connId {
-			conn.Close()
-			ws.connections[uid] = append(conns[:i], conns[i+1:]...)
-
-			if len(ws.connections[uid]) == 0 {
-				delete(ws.connections, uid)
-			}
-			return
+		if conn.connId != connId {
+			continue
 		}
+		rest := make([]*Conn, 0, len(conns)-1)
+		rest = append(rest, conns[:i]...)
+		rest = append(rest, conns[i+1:]...)
+		if len(rest) == 0 {
+			delete(ws.connections, uid)
+			return conn, true
+		}
+		ws.connections[uid] = rest
+		return conn, false
+	}
+	return nil, false
+}
+
+// localUids 本实例上有连接的用户
+func (ws *WebSocketConnections) localUids() []string {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	uids := make([]string, 0, len(ws.connections))
+	for uid := range ws.connections {
+		uids = append(uids, uid)
 	}
+	return uids
 }
 
+// Send2User 投递给用户在本实例和其他实例上的所有连接
 func (ws *WebSocketConnections) Send2User(uid string, message string) error {
+	return ws.send2UserExcept(uid, message, "")
+}
+
+// send2UserExcept 投递时跳过 exceptConnId 对应的连接 发出消息的连接一定在本实例上
+// 转发到其他实例时只能确认已发布 返回 nil 不代表已送达 见 sendRemote
+func (ws *WebSocketConnections) send2UserExcept(uid string, message string, exceptConnId string) error {
+	err := ws.sendLocal(uid, message, exceptConnId)
+	if ws.cluster != nil && ws.cluster.sendRemote(uid, message) {
+		return nil
+	}
+	return err
+}
+
+func (ws *WebSocketConnections) sendLocal(uid string, message string, exceptConnId string) error {
 	ws.mutex.RLock()
 	conns, ok := ws.connections[uid]
 	if !ok {
@@ -88,18 +283,40 @@ func (ws *WebSocketConnections) Send2User(uid string, message string) error {
 		return errors.New("connection not found")
 	}
 
-	targetConns := make([]*Conn, len(conns))
-	copy(targetConns, conns)
+	targetConns := make([]*Conn, 0, len(conns))
+	for _, conn := range conns {
+		if conn.connId != exceptConnId {
+			targetConns = append(targetConns, conn)
+		}
+	}
 	ws.mutex.RUnlock()
 
+	messageType, msgId := messageHeaderOf(message)
 	successCount := 0
+	settled := make([]string, 0)
 	for _, conn := range targetConns {
+		if !conn.accepts(messageType) {
+			// 连接未协商该能力 视为已处理
+			successCount++
+			continue
+		}
 		err := conn.SendMessage(message)
 		if err != nil {
 			log.Infof("send message to user failed, uid: %s, message: %s, err: %v", uid, message, err)
 			continue
 		}
 		successCount++
+		if !conn.Supports(CapabilityAckSeq) {
+			settled = append(settled, conn.ackDeviceId())
+		}
+	}
+	// 已入库的消息发送到不会 ack 的连接后 按发送成功处理
+	if msgId != "" && !messageType.IsEphemeral() && messageType != MessageTypeError {
+		ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+		for _, deviceId := range settled {
+			ws.settleDelivered(ctx, uid, deviceId, []string{msgId})
+		}
+		cal()
 	}
 	if successCount == 0 {
 		return errors.New("send message to user failed")
@@ -108,7 +325,7 @@ func (ws *WebSocketConnections) Send2User(uid string, message string) error {
 }
 
 func (ws *WebSocketConnections) Handle(uid string, connId string, message string) error {
-	if message == "PING" {
+	if message == PingFrame {
 		return ws.sendPONG(uid, connId)
 	}
 
@@ -116,44 +333,181 @@ func (ws *WebSocketConnections) Handle(uid string, connId string, message string
 	if err != nil {
 		return fmt.Errorf("unmarshal msg err:%w", err)
 	}
+	msg.Id = NewMessageId()
 	msg.From = uid
 	msg.Timestamp = time.Now()
 
-	if msg.MessageType == MessageTypeSingle {
-		// 发送单聊消息
-		err := ws.Send2User(msg.Destination, msg.String())
-		// 发送失败，则保存消息到数据库
+	// 消息数量达到阈值后在本条消息处理完成时发起密钥轮换
+	if msg.MessageType != MessageTypeRekey {
+		if conn, err := ws.getConn(uid, connId); err == nil {
+			defer ws.maybeRekey(conn)
+		}
+	}
+
+	switch msg.MessageType {
+	case MessageTypeAck:
+		return ws.handleAck(uid, connId, msg)
+	case MessageTypeSync:
+		conn, err := ws.getConn(uid, connId)
 		if err != nil {
-			log.Infof("send message to user failed, uid: %s, message: %s, err: %v", uid, message, err)
-			err := ws.messagesModel.Create(context.Background(), &schema.Messages{
-				Uid:     msg.Destination,
-				Content: msg.String(),
-			})
-			if err != nil {
-				log.Errorf("create messages error: %s", err)
-				return err
-			}
+			return err
+		}
+		unlock := ws.lockDeliver(uid)
+		ws.replay(uid, conn, msg.Seq)
+		unlock()
+		return nil
+	case MessageTypeSingle:
+		// 被接收方屏蔽的消息既不投递也不保存
+		blocked, err := ws.blocksModel.IsBlocked(context.Background(), msg.Destination, uid)
+		if err != nil {
+			log.Errorf("check blocked error: %s", err)
+			return err
+		}
+		if blocked {
+			return ws.sendError(uid, connId, "message rejected by recipient: "+msg.Destination)
+		}
+		// 发送单聊消息
+		ws.record(msg, []string{msg.Destination})
+		if err := ws.deliver(msg.Destination, msg); err != nil {
+			return err
 		}
+		// 发给自己的消息已经投递到所有设备
+		if msg.Destination != uid {
+			ws.echo(uid, connId, msg)
+		}
+		return nil
+	case MessageTypeSignal:
+		return ws.handleSignal(uid, connId, msg)
+	case MessageTypeRecall, MessageTypeEdit:
+		return ws.handleRecallEdit(uid, connId, msg)
+	case MessageTypeTokenRefresh:
+		return ws.handleTokenRefresh(uid, connId, msg)
+	case MessageTypeRekey:
+		return ws.handleRekey(uid, connId, msg)
+	case MessageTypeHello:
+		return ws.sendError(uid, connId, "hello must be the first message after handshake")
+	case MessageTypeGroup:
+		// 发送群聊消息
+		return ws.handleGroup(uid, connId, msg)
+	case MessageTypeDelivered, MessageTypeRead:
+		return ws.handleReceipt(uid, connId, msg)
+	case MessageTypeBroadcast:
+		// 系统广播 仅管理员可发送
+		if !ws.IsAdmin(uid) {
+			return ws.sendError(uid, connId, "permission denied: broadcast requires admin")
+		}
+		_, err := ws.Broadcast(msg.Content, func(status *BroadcastStatus) {
+			if status.Failed > 0 {
+				_ = ws.sendError(uid, connId, fmt.Sprintf("broadcast %s finished, failed: %d/%d", status.Id, status.Failed, status.Total))
+			}
+		})
+		return err
 	}
 	return nil
 }
 
 func (ws *WebSocketConnections) sendPONG(uid string, connId string) error {
-	ws.mutex.RLock()
-	conns, ok := ws.connections[uid]
-	if !ok {
-		ws.mutex.RUnlock()
-		return errors.New("connection not found")
+	return ws.send2Conn(uid, connId, PongFrame)
+}
+
+// deliver 为接收方分配序号 先写入离线消息表再投递给在线连接 记录在接收方 ack 之后删除
+func (ws *WebSocketConnections) deliver(uid string, msg *Message) error {
+	return ws.deliverExcept(uid, msg, nil)
+}
+
+// deliverExcept 与 deliver 相同 但不投递给 origin 连接 补发时也跳过 origin 所在的设备
+func (ws *WebSocketConnections) deliverExcept(uid string, msg *Message, origin *Conn) error {
+	exceptConnId, exceptDevice := "", ""
+	if origin != nil {
+		exceptConnId, exceptDevice = origin.connId, origin.ackDeviceId()
 	}
 
-	targetConns := make([]*Conn, len(conns))
-	copy(targetConns, conns)
-	ws.mutex.RUnlock()
+	unlock := ws.lockDeliver(uid)
+	defer unlock()
 
-	for _, conn := range targetConns {
+	seq, err := ws.messagesModel.NextSeq(context.Background(), uid)
+	if err != nil {
+		log.Errorf("allocate message seq error: %s", err)
+		return err
+	}
+	m := *msg
+	m.Seq = seq
+	data := m.String()
+	err = ws.messagesModel.Create(context.Background(), &schema.Messages{
+		Uid:           uid,
+		Seq:           seq,
+		MsgId:         m.Id,
+		Content:       data,
+		ExcludeDevice: exceptDevice,
+	})
+	if err != nil {
+		log.Errorf("create messages error: %s", err)
+		return err
+	}
+	if err := ws.send2UserExcept(uid, data, exceptConnId); err != nil {
+		log.Infof("user offline, message queued, uid: %s, msg id: %s", uid, msg.Id)
+	}
+	return nil
+}
+
+// spill 发送队列溢出时 将尚未持久化的消息写入离线消息表 瞬时消息和错误帧直接丢弃
+func (ws *WebSocketConnections) spill(uid string, data string) {
+	msg, err := ToMessage(data)
+	if err != nil || msg.Id == "" || msg.MessageType.IsEphemeral() || msg.MessageType == MessageTypeError {
+		return
+	}
+	ctx, cal := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cal()
+	exists, err := ws.messagesModel.ExistsByMsgId(ctx, uid, msg.Id)
+	if err != nil || exists {
+		return
+	}
+	err = ws.messagesModel.Create(ctx, &schema.Messages{
+		Uid:     uid,
+		Seq:     msg.Seq,
+		MsgId:   msg.Id,
+		Content: data,
+	})
+	if err != nil {
+		log.Errorf("spill message error, uid: %s, err: %s", uid, err)
+	}
+}
+
+func (ws *WebSocketConnections) lockDeliver(uid string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(uid))
+	mu := &ws.deliverLocks[h.Sum32()%deliverLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Notify 向在线用户推送系统事件 不写入离线消息表
+func (ws *WebSocketConnections) Notify(uid string, messageType MessageType, content string) error {
+	msg := NewMessage(messageType, SystemUID, uid, content)
+	return ws.Send2User(uid, msg.String())
+}
+
+// sendError 向发送方的当前连接返回错误帧
+func (ws *WebSocketConnections) sendError(uid string, connId string, reason string) error {
+	msg := NewMessage(MessageTypeError, SystemUID, uid, reason)
+	return ws.send2Conn(uid, connId, msg.String())
+}
+
+func (ws *WebSocketConnections) send2Conn(uid string, connId string, message string) error {
+	conn, err := ws.getConn(uid, connId)
+	if err != nil {
+		return err
+	}
+	return conn.SendMessage(message)
+}
+
+func (ws *WebSocketConnections) getConn(uid string, connId string) (*Conn, error) {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	for _, conn := range ws.connections[uid] {
 		if conn.connId == connId {
-			return conn.SendMessage("PONG")
+			return conn, nil
 		}
 	}
-	return errors.New("connection not found")
+	return nil, errors.New("connection not found")
 }
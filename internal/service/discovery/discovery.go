@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+	"github.com/tangthinker/secret-chat-server/internal/service/keyring"
+)
+
+const (
+	defaultDocumentTTL = 24 * time.Hour
+	// cacheTTL 接口无需鉴权 缓存签名结果 避免每次请求都做签名
+	cacheTTL = time.Minute
+)
+
+type Service struct {
+	keyringService *keyring.Service
+	documentTTL    time.Duration
+
+	mutex    sync.Mutex
+	cached   *proto.DiscoveryResp
+	cachedAt time.Time
+}
+
+func NewService() *Service {
+	documentTTL := core.GlobalHelper.Config.GetDuration("discovery.document-ttl")
+	if documentTTL <= 0 {
+		documentTTL = defaultDocumentTTL
+	}
+	return &Service{
+		keyringService: keyring.Default(),
+		documentTTL:    documentTTL,
+	}
+}
+
+// Get 返回签名后的发现文档
+func (s *Service) Get() (*proto.DiscoveryResp, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cached != nil && time.Since(s.cachedAt) < cacheTTL {
+		return s.cached, nil
+	}
+
+	now := time.Now()
+	doc := &proto.DiscoveryDocument{
+		IssuedAt:          now,
+		ExpiresAt:         now.Add(s.documentTTL),
+		HandshakeVersions: connections.HandshakeVersions(),
+		FrameVersions:     connections.FrameVersions(),
+		Ciphers:           connections.Ciphers(),
+		Capabilities:      connections.Capabilities(),
+	}
+	for _, key := range s.keyringService.Published() {
+		item := &proto.DiscoveryKey{
+			Kid:       key.Kid,
+			PubKey:    key.PubKey,
+			Status:    string(key.Status),
+			NotBefore: key.NotBefore,
+		}
+		if !key.NotAfter.IsZero() {
+			notAfter := key.NotAfter
+			item.NotAfter = &notAfter
+		}
+		doc.Keys = append(doc.Keys, item)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.DiscoveryResp{
+		Document: string(data),
+	}
+	for kid, signature := range s.keyringService.Sign(resp.Document) {
+		resp.Signatures = append(resp.Signatures, &proto.DiscoverySignature{
+			Kid:       kid,
+			Signature: signature,
+		})
+	}
+	sort.Slice(resp.Signatures, func(i, j int) bool {
+		return resp.Signatures[i].Kid < resp.Signatures[j].Kid
+	})
+
+	s.cached = resp
+	s.cachedAt = now
+	return resp, nil
+}
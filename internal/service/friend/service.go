@@ -0,0 +1,159 @@
+package friend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTarget   = errors.New("invalid target user")
+	ErrAlreadyFriends  = errors.New("already friends")
+	ErrRequestNotFound = errors.New("friend request not found")
+)
+
+type Service struct {
+	relationsModel *model.UserRelationsModel
+	userInfoModel  *model.UserInfoModel
+	connService    *connections.WebSocketConnections
+}
+
+func NewService() *Service {
+	return &Service{
+		relationsModel: model.NewUserRelationsModel(),
+		userInfoModel:  model.NewUserInfoModel(),
+		connService:    connections.Default(),
+	}
+}
+
+// SendRequest 发送好友申请 重复申请时返回已存在的待处理申请
+// 对方已向自己发出待处理的申请时直接同意该申请 不再创建新的申请
+func (s *Service) SendRequest(ctx context.Context, uid string, req *proto.FriendRequestSendReq) (*proto.FriendRequestSendResp, error) {
+	if req.UID == uid {
+		return nil, ErrInvalidTarget
+	}
+	exists, err := s.userInfoModel.Exists(ctx, req.UID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrInvalidTarget
+	}
+	isFriend, err := s.relationsModel.IsFriend(ctx, uid, req.UID)
+	if err != nil {
+		return nil, err
+	}
+	if isFriend {
+		return nil, ErrAlreadyFriends
+	}
+
+	reverse, err := s.relationsModel.GetPendingRequest(ctx, req.UID, uid)
+	if err == nil {
+		if err := s.relationsModel.AcceptRequest(ctx, reverse); err != nil {
+			return nil, err
+		}
+		reverse.Status = schema.FriendRequestAccepted
+		s.notify(reverse.FromUID, reverse)
+		return &proto.FriendRequestSendResp{Request: reverse}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	request, err := s.relationsModel.GetPendingRequest(ctx, uid, req.UID)
+	if err == nil {
+		return &proto.FriendRequestSendResp{Request: request}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	request = &schema.FriendRequests{
+		FromUID: uid,
+		ToUID:   req.UID,
+		Remark:  req.Remark,
+		Status:  schema.FriendRequestPending,
+	}
+	if err := s.relationsModel.CreateRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	s.notify(request.ToUID, request)
+	return &proto.FriendRequestSendResp{Request: request}, nil
+}
+
+// Accept 同意好友申请 并通知申请方
+func (s *Service) Accept(ctx context.Context, uid string, req *proto.FriendRequestHandleReq) error {
+	request, err := s.getPendingRequest(ctx, uid, req.RequestId)
+	if err != nil {
+		return err
+	}
+	if err := s.relationsModel.AcceptRequest(ctx, request); err != nil {
+		return err
+	}
+	request.Status = schema.FriendRequestAccepted
+	s.notify(request.FromUID, request)
+	return nil
+}
+
+// Decline 拒绝好友申请 并通知申请方
+func (s *Service) Decline(ctx context.Context, uid string, req *proto.FriendRequestHandleReq) error {
+	request, err := s.getPendingRequest(ctx, uid, req.RequestId)
+	if err != nil {
+		return err
+	}
+	if err := s.relationsModel.DeclineRequest(ctx, request.ID); err != nil {
+		return err
+	}
+	request.Status = schema.FriendRequestDeclined
+	s.notify(request.FromUID, request)
+	return nil
+}
+
+func (s *Service) ListRequests(ctx context.Context, uid string) (*proto.FriendRequestListResp, error) {
+	requests, err := s.relationsModel.ListPendingRequests(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.FriendRequestListResp{Requests: requests}, nil
+}
+
+func (s *Service) ListFriends(ctx context.Context, uid string) (*proto.FriendListResp, error) {
+	friends, err := s.relationsModel.ListFriends(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.FriendListResp{Friends: friends}, nil
+}
+
+func (s *Service) Remove(ctx context.Context, uid string, req *proto.FriendRemoveReq) error {
+	return s.relationsModel.RemoveFriend(ctx, uid, req.UID)
+}
+
+func (s *Service) getPendingRequest(ctx context.Context, uid string, requestId uint) (*schema.FriendRequests, error) {
+	request, err := s.relationsModel.GetRequest(ctx, requestId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, err
+	}
+	if request.ToUID != uid || request.Status != schema.FriendRequestPending {
+		return nil, ErrRequestNotFound
+	}
+	return request, nil
+}
+
+// notify 通过 websocket 推送好友申请事件 对方不在线时由申请列表接口兜底
+func (s *Service) notify(uid string, request *schema.FriendRequests) {
+	content, _ := json.Marshal(request)
+	if err := s.connService.Notify(uid, connections.MessageTypeFriendRequest, string(content)); err != nil {
+		log.Infof("notify friend request failed, uid: %s, err: %v", uid, err)
+	}
+}
@@ -0,0 +1,110 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrGroupNotFound    = errors.New("group not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrRemoveOwner      = errors.New("group owner can not be removed")
+)
+
+type Service struct {
+	groupsModel *model.GroupsModel
+}
+
+func NewService() *Service {
+	return &Service{
+		groupsModel: model.NewGroupsModel(),
+	}
+}
+
+// Create 创建群组 创建者即群主 自动成为成员
+func (s *Service) Create(ctx context.Context, uid string, req *proto.GroupCreateReq) (*proto.GroupCreateResp, error) {
+	members := []string{uid}
+	for _, member := range req.Members {
+		if member == "" || slices.Contains(members, member) {
+			continue
+		}
+		members = append(members, member)
+	}
+	group := &schema.Groups{
+		GroupId: strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Name:    req.Name,
+		Owner:   uid,
+	}
+	if err := s.groupsModel.Create(ctx, group, members); err != nil {
+		return nil, err
+	}
+	return &proto.GroupCreateResp{
+		Group:   group,
+		Members: members,
+	}, nil
+}
+
+// Rename 修改群名 仅群主可操作
+func (s *Service) Rename(ctx context.Context, uid string, req *proto.GroupRenameReq) error {
+	if _, err := s.getOwnedGroup(ctx, uid, req.GroupId); err != nil {
+		return err
+	}
+	return s.groupsModel.Rename(ctx, req.GroupId, req.Name)
+}
+
+// AddMembers 添加成员 仅群主可操作
+func (s *Service) AddMembers(ctx context.Context, uid string, req *proto.GroupMembersReq) error {
+	if _, err := s.getOwnedGroup(ctx, uid, req.GroupId); err != nil {
+		return err
+	}
+	return s.groupsModel.AddMembers(ctx, req.GroupId, req.Members)
+}
+
+// RemoveMembers 移除成员 群主可移除任意成员 普通成员只能移除自己(退群)
+func (s *Service) RemoveMembers(ctx context.Context, uid string, req *proto.GroupMembersReq) error {
+	group, err := s.getGroup(ctx, req.GroupId)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(req.Members, group.Owner) {
+		return ErrRemoveOwner
+	}
+	if group.Owner != uid {
+		for _, member := range req.Members {
+			if member != uid {
+				return ErrPermissionDenied
+			}
+		}
+	}
+	return s.groupsModel.RemoveMembers(ctx, req.GroupId, req.Members)
+}
+
+func (s *Service) getGroup(ctx context.Context, groupId string) (*schema.Groups, error) {
+	group, err := s.groupsModel.GetByGroupId(ctx, groupId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *Service) getOwnedGroup(ctx context.Context, uid string, groupId string) (*schema.Groups, error) {
+	group, err := s.getGroup(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	if group.Owner != uid {
+		return nil, ErrPermissionDenied
+	}
+	return group, nil
+}
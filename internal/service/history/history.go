@@ -0,0 +1,219 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+var (
+	ErrInvalidConversation = errors.New("invalid conversation id")
+	ErrPermissionDenied    = errors.New("permission denied")
+	ErrCursorNotFound      = errors.New("cursor message not found")
+)
+
+type Service struct {
+	historyModel  *model.MessageHistoryModel
+	settingsModel *model.ConversationSettingsModel
+	groupsModel   *model.GroupsModel
+}
+
+func NewService() *Service {
+	s := &Service{
+		historyModel:  model.NewMessageHistoryModel(),
+		settingsModel: model.NewConversationSettingsModel(),
+		groupsModel:   model.NewGroupsModel(),
+	}
+	s.startRetentionTask()
+	return s
+}
+
+// checkAccess 单聊双方与群聊当前成员可以访问会话
+// 群成员只能访问加入之后的历史 返回可访问的起始时间 单聊返回零值
+func (s *Service) checkAccess(ctx context.Context, uid string, conversationId string) (time.Time, error) {
+	uids, groupId, ok := connections.ParseConversationId(conversationId)
+	if !ok {
+		return time.Time{}, ErrInvalidConversation
+	}
+	if groupId == "" {
+		if !slices.Contains(uids, uid) {
+			return time.Time{}, ErrPermissionDenied
+		}
+		return time.Time{}, nil
+	}
+	member, err := s.groupsModel.GetMember(ctx, groupId, uid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return time.Time{}, ErrPermissionDenied
+		}
+		return time.Time{}, err
+	}
+	return member.CreatedAt, nil
+}
+
+// cursorId 将消息 id 游标转换为历史记录的自增 id
+func (s *Service) cursorId(ctx context.Context, conversationId string, msgId string) (uint, error) {
+	if msgId == "" {
+		return 0, nil
+	}
+	history, err := s.historyModel.GetByMsgId(ctx, msgId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrCursorNotFound
+		}
+		return 0, err
+	}
+	if history.ConversationId != conversationId {
+		return 0, ErrCursorNotFound
+	}
+	return history.ID, nil
+}
+
+// List 游标分页查询会话历史
+func (s *Service) List(ctx context.Context, uid string, req *proto.HistoryListReq) (*proto.HistoryListResp, error) {
+	since, err := s.checkAccess(ctx, uid, req.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+	beforeId, err := s.cursorId(ctx, req.ConversationId, req.Before)
+	if err != nil {
+		return nil, err
+	}
+	afterId, err := s.cursorId(ctx, req.ConversationId, req.After)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+
+	// 多取一条判断是否还有更多
+	list, err := s.historyModel.List(ctx, req.ConversationId, since, beforeId, afterId, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.HistoryListResp{
+		HasMore: len(list) > limit,
+	}
+	if resp.HasMore {
+		// 向后翻页时丢弃最新的一条 否则丢弃最早的一条
+		if afterId > 0 && beforeId == 0 {
+			list = list[:limit]
+		} else {
+			list = list[1:]
+		}
+	}
+	resp.Messages = make([]json.RawMessage, 0, len(list))
+	for _, history := range list {
+		resp.Messages = append(resp.Messages, json.RawMessage(history.Content))
+	}
+	return resp, nil
+}
+
+// GetSettings 会话没有设置时返回默认值
+func (s *Service) GetSettings(ctx context.Context, uid string, conversationId string) (*schema.ConversationSettings, error) {
+	if _, err := s.checkAccess(ctx, uid, conversationId); err != nil {
+		return nil, err
+	}
+	settings, err := s.settingsModel.Get(ctx, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = &schema.ConversationSettings{ConversationId: conversationId}
+	}
+	settings.HistoryEnabledBy = strings.Trim(settings.HistoryEnabledBy, ",")
+	return settings, nil
+}
+
+// UpdateSettings 会话内任一成员都可以修改 关闭历史记录不会删除已有记录
+// 单聊的历史记录开关只修改当前用户的选择 双方都开启后才开始记录
+func (s *Service) UpdateSettings(ctx context.Context, uid string, req *proto.ConversationSettingsUpdateReq) error {
+	if _, err := s.checkAccess(ctx, uid, req.ConversationId); err != nil {
+		return err
+	}
+	settings := &schema.ConversationSettings{
+		ConversationId: req.ConversationId,
+		UpdatedBy:      uid,
+	}
+	columns := make([]string, 0, 4)
+	uids, _, _ := connections.ParseConversationId(req.ConversationId)
+	if req.HistoryEnabled != nil && len(uids) == 0 {
+		settings.HistoryEnabled = *req.HistoryEnabled
+		columns = append(columns, "history_enabled")
+	}
+	if req.RetentionSeconds != nil {
+		settings.RetentionSeconds = *req.RetentionSeconds
+		columns = append(columns, "retention_seconds")
+	}
+	if req.ExpireSeconds != nil {
+		settings.ExpireSeconds = *req.ExpireSeconds
+		columns = append(columns, "expire_seconds")
+	}
+	if req.ExpireAfterReadSeconds != nil {
+		settings.ExpireAfterReadSeconds = *req.ExpireAfterReadSeconds
+		columns = append(columns, "expire_after_read_seconds")
+	}
+	if len(columns) > 0 || req.HistoryEnabled == nil {
+		if err := s.settingsModel.Upsert(ctx, settings, columns); err != nil {
+			return err
+		}
+	}
+	if req.HistoryEnabled != nil && len(uids) > 0 {
+		return s.settingsModel.SetHistoryOptIn(ctx, req.ConversationId, uid, uids, *req.HistoryEnabled)
+	}
+	return nil
+}
+
+// startRetentionTask 定期按会话的保留时长清理历史消息
+func (s *Service) startRetentionTask() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startRetentionTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(time.Hour)
+		for range ticker.C {
+			s.cleanExpired()
+		}
+	}()
+}
+
+func (s *Service) cleanExpired() {
+	ctx := context.Background()
+	list, err := s.settingsModel.ListWithRetention(ctx)
+	if err != nil {
+		log.Errorf("list conversation settings error: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, settings := range list {
+		before := now.Add(-time.Duration(settings.RetentionSeconds) * time.Second)
+		count, err := s.historyModel.DeleteBefore(ctx, settings.ConversationId, before)
+		if err != nil {
+			log.Errorf("clean message history error, conversation id: %s, err: %v", settings.ConversationId, err)
+			continue
+		}
+		if count > 0 {
+			log.Infof("clean: cleaned %d history messages of %s", count, settings.ConversationId)
+		}
+	}
+}
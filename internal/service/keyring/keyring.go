@@ -0,0 +1,132 @@
+package keyring
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+	"github.com/tangthinker/secret-chat-server/core"
+	keyringPkg "github.com/tangthinker/secret-chat-server/pkg/keyring"
+)
+
+type Service struct {
+	path string
+	// fromFile 密钥是否来自密钥文件 来自单个配置密钥时不需要重新加载
+	fromFile bool
+
+	mutex   sync.RWMutex
+	keyring *keyringPkg.Keyring
+	modTime time.Time
+}
+
+var (
+	defaultService     *Service
+	defaultServiceOnce sync.Once
+)
+
+// Default 返回共享的密钥服务
+func Default() *Service {
+	defaultServiceOnce.Do(func() {
+		defaultService = NewService()
+	})
+	return defaultService
+}
+
+// NewService 优先使用 keyring-path 指定的密钥文件
+// 未配置或文件不存在时退回到单个 ecdsa-priv-key / ecdsa-pub-key
+func NewService() *Service {
+	s := &Service{
+		path: core.GlobalHelper.Config.GetString("encrypt-conn.keyring-path"),
+	}
+	if s.path != "" {
+		err := s.reload()
+		if err == nil {
+			s.fromFile = true
+			s.warnMissingConfigKey()
+			return s
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			panic("load keyring: " + err.Error())
+		}
+		log.Infof("keyring file %s not found, fallback to single key", s.path)
+	}
+	privKey := core.GlobalHelper.Config.GetString("encrypt-conn.ecdsa-priv-key")
+	pubKey := core.GlobalHelper.Config.GetString("encrypt-conn.ecdsa-pub-key")
+	s.keyring = keyringPkg.FromSingleKey(privKey, pubKey)
+	return s
+}
+
+// reload 密钥文件修改后重新加载 轮换命令写入新文件后无需重启服务
+func (s *Service) reload() error {
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.mutex.RLock()
+	unchanged := s.keyring != nil && stat.ModTime().Equal(s.modTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	kr, err := keyringPkg.Load(s.path)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.keyring = kr
+	s.modTime = stat.ModTime()
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *Service) current() *keyringPkg.Keyring {
+	if s.fromFile {
+		// 加载失败时继续使用上一次成功加载的密钥
+		if err := s.reload(); err != nil {
+			log.Errorf("reload keyring error: %v", err)
+		}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.keyring
+}
+
+// Signing 选择握手签名密钥 kid 为空时使用当前生效的密钥
+func (s *Service) Signing(kid string) (*keyringPkg.Key, error) {
+	return s.current().Signing(kid, time.Now())
+}
+
+// Published 当前对外发布的公钥
+func (s *Service) Published() []*keyringPkg.Key {
+	return s.current().Published(time.Now())
+}
+
+// Sign 使用每把可用的密钥分别签名 返回 kid 与签名
+func (s *Service) Sign(data string) map[string]string {
+	kr := s.current()
+	now := time.Now()
+	signatures := make(map[string]string)
+	for _, key := range kr.Keys {
+		if key.PrivKey == "" || !key.Usable(now) {
+			continue
+		}
+		if signature := encrypt.SignECDSA(data, key.PrivKey); signature != "" {
+			signatures[key.Kid] = signature
+		}
+	}
+	return signatures
+}
+
+// warnMissingConfigKey 密钥文件中没有配置里的密钥时 固定了该公钥的客户端将无法握手
+func (s *Service) warnMissingConfigKey() {
+	pubKey := core.GlobalHelper.Config.GetString("encrypt-conn.ecdsa-pub-key")
+	if pubKey == "" {
+		return
+	}
+	if s.current().Get(keyringPkg.KeyId(pubKey)) == nil {
+		log.Warnf("configured ecdsa key %s is not in keyring %s, clients pinned to it will fail the handshake", keyringPkg.KeyId(pubKey), s.path)
+	}
+}
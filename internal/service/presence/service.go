@@ -0,0 +1,72 @@
+package presence
+
+import (
+	"context"
+
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+)
+
+type Service struct {
+	presenceModel  *model.UserPresenceModel
+	relationsModel *model.UserRelationsModel
+	blocksModel    *model.UserBlocksModel
+	connService    *connections.WebSocketConnections
+}
+
+func NewService() *Service {
+	return &Service{
+		presenceModel:  model.NewUserPresenceModel(),
+		relationsModel: model.NewUserRelationsModel(),
+		blocksModel:    model.NewUserBlocksModel(),
+		connService:    connections.Default(),
+	}
+}
+
+// Get 批量查询在线状态 只返回自己和好友的状态 其他用户 与自己互有屏蔽的好友 以及隐藏状态的好友始终显示为离线且不返回最后在线时间
+func (s *Service) Get(ctx context.Context, uid string, req *proto.PresenceGetReq) (*proto.PresenceGetResp, error) {
+	presences, err := s.presenceModel.GetByUids(ctx, req.UIDs)
+	if err != nil {
+		return nil, err
+	}
+	friends, err := s.relationsModel.ListFriends(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.blocksModel.ListBlockedEither(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(friends)+1)
+	visible[uid] = true
+	for _, friend := range friends {
+		visible[friend] = !blocked[friend]
+	}
+	resp := &proto.PresenceGetResp{
+		Presences: make([]*proto.PresenceInfo, 0, len(req.UIDs)),
+	}
+	for _, target := range req.UIDs {
+		info := &proto.PresenceInfo{UID: target}
+		presence, ok := presences[target]
+		if !visible[target] || (ok && presence.Hidden && target != uid) {
+			resp.Presences = append(resp.Presences, info)
+			continue
+		}
+		info.Online = s.connService.IsOnline(target)
+		if ok {
+			info.LastSeen = presence.LastSeen
+		}
+		resp.Presences = append(resp.Presences, info)
+	}
+	return resp, nil
+}
+
+// UpdateSetting 在线时切换隐藏状态 好友会相应收到离线或在线事件
+func (s *Service) UpdateSetting(ctx context.Context, uid string, req *proto.PresenceSettingReq) error {
+	if err := s.presenceModel.SetHidden(ctx, uid, req.Hidden); err != nil {
+		return err
+	}
+	s.connService.OnPresenceHidden(uid, req.Hidden)
+	return nil
+}
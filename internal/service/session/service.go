@@ -0,0 +1,60 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/tangthinker/secret-chat-server/internal/proto"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
+)
+
+const kickReason = "kicked by another session"
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type Service struct {
+	connService *connections.WebSocketConnections
+}
+
+func NewService() *Service {
+	return &Service{
+		connService: connections.Default(),
+	}
+}
+
+func (s *Service) List(uid string) *proto.SessionListResp {
+	sessions := s.connService.ListSessions(uid)
+	resp := &proto.SessionListResp{
+		Sessions: make([]*proto.SessionInfo, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &proto.SessionInfo{
+			ConnId:      session.ConnId,
+			DeviceName:  session.DeviceName,
+			DeviceId:    session.DeviceId,
+			IP:          session.IP,
+			ConnectedAt: session.ConnectedAt,
+			LastActive:  session.LastActive,
+		})
+	}
+	return resp
+}
+
+func (s *Service) Kick(uid string, req *proto.SessionKickReq) error {
+	if !s.connService.Kick(uid, req.ConnId, kickReason) {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *Service) Rename(uid string, req *proto.SessionRenameReq) error {
+	if !s.connService.RenameSession(uid, req.ConnId, req.DeviceName) {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *Service) KickOthers(uid string, req *proto.SessionKickOthersReq) *proto.SessionKickOthersResp {
+	return &proto.SessionKickOthersResp{
+		Kicked: s.connService.KickOthers(uid, req.ConnId, kickReason),
+	}
+}
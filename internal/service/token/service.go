@@ -0,0 +1,102 @@
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/internal/model"
+	"github.com/tangthinker/secret-chat-server/internal/model/schema"
+	"github.com/tangthinker/user-center/pkg"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// defaultRevokedRetention 与用户中心的 token 有效期一致
+// 注销后的 token 不再经过用户中心校验 不会被续期 超过该时长后必然已过期
+const defaultRevokedRetention = 15 * 24 * time.Hour
+
+type Service struct {
+	revokedModel     *model.RevokedTokensModel
+	revokedRetention time.Duration
+}
+
+var (
+	defaultService     *Service
+	defaultServiceOnce sync.Once
+)
+
+// Default 返回共享的 token 服务
+func Default() *Service {
+	defaultServiceOnce.Do(func() {
+		defaultService = NewService()
+	})
+	return defaultService
+}
+
+func NewService() *Service {
+	revokedRetention := core.GlobalHelper.Config.GetDuration("token.revoked-retention")
+	if revokedRetention <= 0 {
+		revokedRetention = defaultRevokedRetention
+	}
+	s := &Service{
+		revokedModel:     model.NewRevokedTokensModel(),
+		revokedRetention: revokedRetention,
+	}
+	s.startCleanTask()
+	return s
+}
+
+// Verify 检查 token 是否已被注销 再通过用户中心校验 返回 token 所属的 uid
+// 用户中心校验时会为 token 续期 因此已注销的 token 不再交给用户中心
+func (s *Service) Verify(ctx context.Context, token string) (string, error) {
+	revoked, err := s.revokedModel.Exists(ctx, Hash(token))
+	if err != nil {
+		return "", err
+	}
+	if revoked {
+		return "", ErrTokenRevoked
+	}
+	return pkg.TokenValid(token)
+}
+
+// Revoke 注销 token 之后使用该 token 的请求和连接都会被拒绝
+func (s *Service) Revoke(ctx context.Context, uid string, token string) error {
+	return s.revokedModel.Create(ctx, &schema.RevokedTokens{
+		TokenHash: Hash(token),
+		Uid:       uid,
+	})
+}
+
+// Hash token 摘要 注销记录和实例间的注销通知只使用摘要
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startCleanTask 每天清理超过保留时长的注销记录
+func (s *Service) startCleanTask() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("startCleanTask error: %v", err)
+			}
+		}()
+		ticker := time.NewTicker(24 * time.Hour)
+		for range ticker.C {
+			count, err := s.revokedModel.DeleteBefore(context.Background(), time.Now().Add(-s.revokedRetention))
+			if err != nil {
+				log.Errorf("clean revoked tokens error: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Infof("clean: cleaned %d revoked tokens", count)
+			}
+		}
+	}()
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"flag"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tangthinker/secret-chat-server/core"
 	"github.com/tangthinker/secret-chat-server/core/server"
 	"github.com/tangthinker/secret-chat-server/internal/middleware"
 	"github.com/tangthinker/secret-chat-server/internal/router"
+	"github.com/tangthinker/secret-chat-server/internal/service/connections"
 	userPkg "github.com/tangthinker/user-center/pkg"
 )
 
@@ -18,7 +20,17 @@ func main() {
 
 	core.Init(*configPath)
 
-	app := fiber.New()
+	if err := connections.Init(); err != nil {
+		log.Fatalf("init connections failed: %v", err)
+	}
+
+	// 只有来自可信代理的请求才使用 X-Forwarded-For 中的客户端地址
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          core.GlobalHelper.Config.GetStringSlice("server.trusted-proxies"),
+		EnableIPValidation:      true,
+	})
 
 	app.Use(middleware.LoggerInConsole())
 
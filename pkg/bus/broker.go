@@ -0,0 +1,155 @@
+package bus
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const brokerWriteTimeout = 5 * time.Second
+
+// Broker 轻量的 TCP 消息代理 负责在 TCPBus 客户端之间按主题转发消息
+// 可以独立部署 也可以嵌入到某一个服务实例中作为本地替身
+type Broker struct {
+	addr     string
+	listener net.Listener
+
+	mutex   sync.RWMutex
+	subs    map[string]map[*brokerClient]struct{}
+	clients map[*brokerClient]struct{}
+	closed  bool
+}
+
+type brokerClient struct {
+	conn   net.Conn
+	mutex  sync.Mutex
+	writer *bufio.Writer
+}
+
+func NewBroker(addr string) *Broker {
+	return &Broker{
+		addr:    addr,
+		subs:    make(map[string]map[*brokerClient]struct{}),
+		clients: make(map[*brokerClient]struct{}),
+	}
+}
+
+// Start 开始监听 返回前监听已就绪
+func (b *Broker) Start() error {
+	listener, err := net.Listen("tcp", b.addr)
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	go b.acceptLoop()
+	return nil
+}
+
+// Addr 实际监听地址 监听端口为 0 时可用于获取系统分配的端口
+func (b *Broker) Addr() string {
+	if b.listener == nil {
+		return b.addr
+	}
+	return b.listener.Addr().String()
+}
+
+func (b *Broker) Close() error {
+	b.mutex.Lock()
+	b.closed = true
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for client := range b.clients {
+		clients = append(clients, client)
+	}
+	b.mutex.Unlock()
+
+	for _, client := range clients {
+		_ = client.conn.Close()
+	}
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Close()
+}
+
+func (b *Broker) acceptLoop() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("bus broker: accept error: %v", err)
+			continue
+		}
+		client := &brokerClient{
+			conn:   conn,
+			writer: bufio.NewWriter(conn),
+		}
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			_ = conn.Close()
+			return
+		}
+		b.clients[client] = struct{}{}
+		b.mutex.Unlock()
+		go b.serve(client)
+	}
+}
+
+func (b *Broker) serve(client *brokerClient) {
+	defer b.remove(client)
+	reader := bufio.NewReader(client.conn)
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		switch f.op {
+		case opSub:
+			b.mutex.Lock()
+			if _, ok := b.subs[f.subject]; !ok {
+				b.subs[f.subject] = make(map[*brokerClient]struct{})
+			}
+			b.subs[f.subject][client] = struct{}{}
+			b.mutex.Unlock()
+		case opPub:
+			b.forward(f.subject, f.payload)
+		}
+	}
+}
+
+func (b *Broker) forward(subject string, payload []byte) {
+	b.mutex.RLock()
+	targets := make([]*brokerClient, 0, len(b.subs[subject]))
+	for client := range b.subs[subject] {
+		targets = append(targets, client)
+	}
+	b.mutex.RUnlock()
+
+	for _, client := range targets {
+		client.mutex.Lock()
+		_ = client.conn.SetWriteDeadline(time.Now().Add(brokerWriteTimeout))
+		err := writeFrame(client.writer, opMsg, subject, payload)
+		client.mutex.Unlock()
+		if err != nil {
+			_ = client.conn.Close()
+		}
+	}
+}
+
+func (b *Broker) remove(client *brokerClient) {
+	_ = client.conn.Close()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.clients, client)
+	for subject, clients := range b.subs {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(b.subs, subject)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package bus
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrBusClosed = errors.New("bus closed")
+
+// Handler 处理订阅主题上收到的消息
+type Handler func(subject string, data []byte)
+
+// Bus 实例间消息总线 主题语义与 NATS 相同 消息投递给该主题的所有订阅者
+type Bus interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handler Handler) error
+	Close() error
+}
+
+// MemoryBus 进程内总线 同一进程内的多个实例共享同一个 MemoryBus 即可互通
+type MemoryBus struct {
+	mutex    sync.RWMutex
+	handlers map[string][]Handler
+	closed   bool
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		handlers: make(map[string][]Handler),
+	}
+}
+
+func (b *MemoryBus) Publish(subject string, data []byte) error {
+	b.mutex.RLock()
+	if b.closed {
+		b.mutex.RUnlock()
+		return ErrBusClosed
+	}
+	handlers := make([]Handler, len(b.handlers[subject]))
+	copy(handlers, b.handlers[subject])
+	b.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(subject, data)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(subject string, handler Handler) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ErrBusClosed
+	}
+	b.handlers[subject] = append(b.handlers[subject], handler)
+	return nil
+}
+
+func (b *MemoryBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.handlers = make(map[string][]Handler)
+	return nil
+}
@@ -0,0 +1,112 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func startTestBroker(t *testing.T, addr string) *Broker {
+	t.Helper()
+	broker := NewBroker(addr)
+	if err := broker.Start(); err != nil {
+		t.Fatalf("start broker: %v", err)
+	}
+	return broker
+}
+
+func newTestBus(t *testing.T, addr string) *TCPBus {
+	t.Helper()
+	b, err := NewTCPBus(addr)
+	if err != nil {
+		t.Fatalf("connect broker: %v", err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+// publishUntil 订阅与发布走不同的连接 无法保证先后 因此重复发布直到收到
+func publishUntil(t *testing.T, b Bus, subject string, data string, received <-chan string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		_ = b.Publish(subject, []byte(data))
+		select {
+		case got := <-received:
+			// 之前重复发布的消息可能晚到
+			if got == data {
+				return
+			}
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatalf("message on %s not received", subject)
+		}
+	}
+}
+
+func TestTCPBusPublishSubscribe(t *testing.T) {
+	broker := startTestBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+
+	sub := newTestBus(t, broker.Addr())
+	pub := newTestBus(t, broker.Addr())
+
+	received := make(chan string, 64)
+	if err := sub.Subscribe("test.subject", func(subject string, data []byte) {
+		received <- string(data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	other := make(chan string, 64)
+	if err := sub.Subscribe("test.other", func(subject string, data []byte) {
+		other <- string(data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	publishUntil(t, pub, "test.subject", "hello", received)
+	if len(other) != 0 {
+		t.Fatal("message delivered to another subject")
+	}
+
+	// 负载中的换行和空格不影响分帧
+	publishUntil(t, pub, "test.subject", "multi line\npayload ", received)
+}
+
+func TestTCPBusReconnect(t *testing.T) {
+	broker := startTestBroker(t, "127.0.0.1:0")
+	addr := broker.Addr()
+
+	sub := newTestBus(t, addr)
+	pub := newTestBus(t, addr)
+	received := make(chan string, 64)
+	if err := sub.Subscribe("test.subject", func(subject string, data []byte) {
+		received <- string(data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	publishUntil(t, pub, "test.subject", "before", received)
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("close broker: %v", err)
+	}
+	restarted := startTestBroker(t, addr)
+	defer restarted.Close()
+
+	// 客户端重连后自动恢复订阅
+	publishUntil(t, pub, "test.subject", "after", received)
+}
+
+func TestTCPBusClosed(t *testing.T) {
+	broker := startTestBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+
+	b := newTestBus(t, broker.Addr())
+	if err := b.Close(); err != nil {
+		t.Fatalf("close bus: %v", err)
+	}
+	if err := b.Publish("test.subject", []byte("hello")); err != ErrBusClosed {
+		t.Fatalf("publish after close: %v, want %v", err, ErrBusClosed)
+	}
+}
@@ -0,0 +1,82 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 文本协议 与 NATS 类似 每帧一行头部 带负载的帧在头部之后紧跟负载和换行
+//
+//	SUB <subject>\n
+//	PUB <subject> <size>\n<payload>\n
+//	MSG <subject> <size>\n<payload>\n
+const (
+	opSub = "SUB"
+	opPub = "PUB"
+	opMsg = "MSG"
+)
+
+// maxPayloadSize 单帧负载上限
+const maxPayloadSize = 8 << 20
+
+type frame struct {
+	op      string
+	subject string
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (*frame, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid frame: %q", line)
+	}
+	f := &frame{op: parts[0], subject: parts[1]}
+	switch f.op {
+	case opSub:
+		return f, nil
+	case opPub, opMsg:
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid frame: %q", line)
+		}
+		size, err := strconv.Atoi(parts[2])
+		if err != nil || size < 0 || size > maxPayloadSize {
+			return nil, fmt.Errorf("invalid payload size: %q", line)
+		}
+		payload := make([]byte, size+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		f.payload = payload[:size]
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown op: %s", f.op)
+}
+
+func writeFrame(w *bufio.Writer, op string, subject string, payload []byte) error {
+	if strings.ContainsAny(subject, " \t\r\n") || subject == "" {
+		return fmt.Errorf("invalid subject: %q", subject)
+	}
+	var err error
+	if op == opSub {
+		_, err = fmt.Fprintf(w, "%s %s\n", op, subject)
+	} else {
+		_, err = fmt.Fprintf(w, "%s %s %d\n", op, subject, len(payload))
+		if err == nil {
+			_, err = w.Write(payload)
+		}
+		if err == nil {
+			err = w.WriteByte('\n')
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,168 @@
+package bus
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	tcpDialTimeout  = 3 * time.Second
+	tcpWriteTimeout = 5 * time.Second
+	tcpMaxBackoff   = 10 * time.Second
+)
+
+var ErrNotConnected = errors.New("bus not connected")
+
+// TCPBus 连接到 Broker 的总线客户端 断线后自动重连并恢复订阅
+type TCPBus struct {
+	addr string
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	writer   *bufio.Writer
+	handlers map[string][]Handler
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func NewTCPBus(addr string) (*TCPBus, error) {
+	b := &TCPBus{
+		addr:     addr,
+		handlers: make(map[string][]Handler),
+		closed:   make(chan struct{}),
+	}
+	conn, err := net.DialTimeout("tcp", addr, tcpDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b.setConn(conn)
+	go b.readLoop(conn)
+	return b, nil
+}
+
+func (b *TCPBus) Publish(subject string, data []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.write(opPub, subject, data)
+}
+
+func (b *TCPBus) Subscribe(subject string, handler Handler) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, subscribed := b.handlers[subject]
+	b.handlers[subject] = append(b.handlers[subject], handler)
+	if subscribed {
+		return nil
+	}
+	return b.write(opSub, subject, nil)
+}
+
+func (b *TCPBus) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.conn != nil {
+			err = b.conn.Close()
+		}
+	})
+	return err
+}
+
+// write 调用方需持有 mutex
+func (b *TCPBus) write(op string, subject string, data []byte) error {
+	select {
+	case <-b.closed:
+		return ErrBusClosed
+	default:
+	}
+	if b.conn == nil {
+		return ErrNotConnected
+	}
+	_ = b.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	if err := writeFrame(b.writer, op, subject, data); err != nil {
+		_ = b.conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (b *TCPBus) setConn(conn net.Conn) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.conn = conn
+	if conn == nil {
+		b.writer = nil
+		return
+	}
+	b.writer = bufio.NewWriter(conn)
+	for subject := range b.handlers {
+		if err := b.write(opSub, subject, nil); err != nil {
+			log.Printf("bus: resubscribe %s error: %v", subject, err)
+		}
+	}
+}
+
+func (b *TCPBus) readLoop(conn net.Conn) {
+	for {
+		b.consume(conn)
+		b.setConn(nil)
+		conn = b.reconnect()
+		if conn == nil {
+			return
+		}
+		b.setConn(conn)
+	}
+}
+
+func (b *TCPBus) consume(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			select {
+			case <-b.closed:
+			default:
+				log.Printf("bus: read from %s error: %v", b.addr, err)
+			}
+			_ = conn.Close()
+			return
+		}
+		if f.op != opMsg {
+			continue
+		}
+		b.mutex.Lock()
+		handlers := make([]Handler, len(b.handlers[f.subject]))
+		copy(handlers, b.handlers[f.subject])
+		b.mutex.Unlock()
+		for _, handler := range handlers {
+			handler(f.subject, f.payload)
+		}
+	}
+}
+
+// reconnect 指数退避重连 总线关闭时返回 nil
+func (b *TCPBus) reconnect() net.Conn {
+	backoff := 100 * time.Millisecond
+	for {
+		select {
+		case <-b.closed:
+			return nil
+		case <-time.After(backoff):
+		}
+		conn, err := net.DialTimeout("tcp", b.addr, tcpDialTimeout)
+		if err == nil {
+			return conn
+		}
+		backoff *= 2
+		if backoff > tcpMaxBackoff {
+			backoff = tcpMaxBackoff
+		}
+	}
+}
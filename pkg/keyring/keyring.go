@@ -0,0 +1,205 @@
+package keyring
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyExpired  = errors.New("key expired")
+	ErrNoActiveKey = errors.New("no active key")
+)
+
+// Status 密钥状态
+type Status string
+
+const (
+	// StatusActive 当前签名密钥 客户端未指定 kid 时使用
+	StatusActive Status = "active"
+	// StatusNext 提前发布的下一把密钥 客户端可以提前固定 指定 kid 时即可使用
+	StatusNext Status = "next"
+	// StatusRetired 已退役 在 NotAfter 之前仍可使用 以兼容尚未更新的客户端
+	StatusRetired Status = "retired"
+)
+
+// Key ECDSA 签名密钥 PrivKey 与 PubKey 为 hex 编码
+type Key struct {
+	Kid       string    `json:"kid"`
+	PrivKey   string    `json:"priv_key,omitempty"`
+	PubKey    string    `json:"pub_key"`
+	Status    Status    `json:"status"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+// Usable 密钥在 now 时刻是否可以用于签名
+func (k *Key) Usable(now time.Time) bool {
+	if !k.NotAfter.IsZero() && now.After(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// Public 去掉私钥后的副本 用于对外发布
+func (k *Key) Public() *Key {
+	pub := *k
+	pub.PrivKey = ""
+	return &pub
+}
+
+// Keyring 服务端签名密钥集合
+type Keyring struct {
+	Keys []*Key `json:"keys"`
+}
+
+// KeyId 由公钥计算密钥 ID
+func KeyId(pubKey string) string {
+	sum := sha256.Sum256([]byte(pubKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewKey 生成一把新的密钥
+func NewKey(status Status, notBefore time.Time) *Key {
+	pubKey, privKey := encrypt.GenerateKeyPairECDSA()
+	return &Key{
+		Kid:       KeyId(pubKey),
+		PrivKey:   privKey,
+		PubKey:    pubKey,
+		Status:    status,
+		NotBefore: notBefore,
+	}
+}
+
+// FromSingleKey 由单个密钥构造密钥集合 兼容只配置了一把密钥的部署
+func FromSingleKey(privKey string, pubKey string) *Keyring {
+	return &Keyring{
+		Keys: []*Key{
+			{
+				Kid:     KeyId(pubKey),
+				PrivKey: privKey,
+				PubKey:  pubKey,
+				Status:  StatusActive,
+			},
+		},
+	}
+}
+
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kr Keyring
+	if err := json.Unmarshal(data, &kr); err != nil {
+		return nil, fmt.Errorf("unmarshal keyring: %w", err)
+	}
+	return &kr, nil
+}
+
+// Save 先写临时文件再重命名 避免服务端读到写了一半的文件
+func (kr *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(kr, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (kr *Keyring) Get(kid string) *Key {
+	for _, key := range kr.Keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Active 当前生效的签名密钥 有多把时取 NotBefore 最晚且已生效的一把
+func (kr *Keyring) Active(now time.Time) *Key {
+	var active *Key
+	for _, key := range kr.Keys {
+		if key.Status != StatusActive || now.Before(key.NotBefore) || !key.Usable(now) {
+			continue
+		}
+		if active == nil || key.NotBefore.After(active.NotBefore) {
+			active = key
+		}
+	}
+	return active
+}
+
+// Signing 选择握手签名密钥 kid 为空时使用当前生效的密钥
+func (kr *Keyring) Signing(kid string, now time.Time) (*Key, error) {
+	if kid == "" {
+		active := kr.Active(now)
+		if active == nil {
+			return nil, ErrNoActiveKey
+		}
+		return active, nil
+	}
+	key := kr.Get(kid)
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	if !key.Usable(now) {
+		return nil, ErrKeyExpired
+	}
+	return key, nil
+}
+
+// Published 当前对外发布的公钥 包括生效 预发布 以及仍在宽限期内的退役密钥
+func (kr *Keyring) Published(now time.Time) []*Key {
+	keys := make([]*Key, 0, len(kr.Keys))
+	for _, key := range kr.Keys {
+		if key.Usable(now) {
+			keys = append(keys, key.Public())
+		}
+	}
+	return keys
+}
+
+// Rotate 执行一次轮换
+// 预发布密钥转为生效 原生效密钥退役并在 retireAfter 后失效 同时生成新的预发布密钥
+// 没有预发布密钥时直接生成一把新的生效密钥 已过期的退役密钥会被清理
+func (kr *Keyring) Rotate(now time.Time, retireAfter time.Duration) {
+	var next *Key
+	for _, key := range kr.Keys {
+		if key.Status == StatusNext && next == nil {
+			next = key
+		}
+	}
+	for _, key := range kr.Keys {
+		if key.Status == StatusActive {
+			key.Status = StatusRetired
+			key.NotAfter = now.Add(retireAfter)
+		}
+	}
+	if next == nil {
+		next = NewKey(StatusNext, now)
+		kr.Keys = append(kr.Keys, next)
+	}
+	next.Status = StatusActive
+	next.NotBefore = now
+	kr.Keys = append(kr.Keys, NewKey(StatusNext, now))
+
+	kr.Keys = slices.DeleteFunc(kr.Keys, func(key *Key) bool {
+		return key.Status == StatusRetired && !key.Usable(now)
+	})
+}
@@ -0,0 +1,191 @@
+package keyring
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 固定时钟 所有时间都相对于 epoch
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) time.Time {
+	return epoch.Add(d)
+}
+
+func keysWithStatus(kr *Keyring, status Status) []*Key {
+	var keys []*Key
+	for _, key := range kr.Keys {
+		if key.Status == status {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestRotate(t *testing.T) {
+	const retireAfter = 24 * time.Hour
+	kr := &Keyring{Keys: []*Key{NewKey(StatusActive, epoch)}}
+	first := kr.Keys[0]
+
+	// 没有预发布密钥时生成新的生效密钥和预发布密钥
+	kr.Rotate(at(time.Hour), retireAfter)
+	if first.Status != StatusRetired || !first.NotAfter.Equal(at(time.Hour+retireAfter)) {
+		t.Fatalf("first key: status %s, not after %v", first.Status, first.NotAfter)
+	}
+	active, next := keysWithStatus(kr, StatusActive), keysWithStatus(kr, StatusNext)
+	if len(active) != 1 || len(next) != 1 || len(kr.Keys) != 3 {
+		t.Fatalf("got %d active, %d next, %d keys", len(active), len(next), len(kr.Keys))
+	}
+	if !active[0].NotBefore.Equal(at(time.Hour)) {
+		t.Fatalf("active not before: %v", active[0].NotBefore)
+	}
+
+	// 预发布密钥转为生效 kid 不变
+	second, pinned := active[0], next[0]
+	kr.Rotate(at(2*time.Hour), retireAfter)
+	if pinned.Status != StatusActive || !pinned.NotBefore.Equal(at(2*time.Hour)) {
+		t.Fatalf("next key: status %s, not before %v", pinned.Status, pinned.NotBefore)
+	}
+	if second.Status != StatusRetired || kr.Get(first.Kid) == nil {
+		t.Fatal("retired keys should be kept within retireAfter")
+	}
+
+	// 超过 retireAfter 的退役密钥被清理
+	kr.Rotate(at(time.Hour+retireAfter+time.Second), retireAfter)
+	if kr.Get(first.Kid) != nil {
+		t.Fatal("expired retired key was not removed")
+	}
+	if kr.Get(second.Kid) == nil {
+		t.Fatal("retired key removed before expiry")
+	}
+	if len(keysWithStatus(kr, StatusActive)) != 1 || len(keysWithStatus(kr, StatusNext)) != 1 {
+		t.Fatal("rotation should leave one active and one next key")
+	}
+}
+
+func TestActive(t *testing.T) {
+	older := &Key{Kid: "older", Status: StatusActive, NotBefore: epoch}
+	newer := &Key{Kid: "newer", Status: StatusActive, NotBefore: at(time.Hour)}
+	future := &Key{Kid: "future", Status: StatusActive, NotBefore: at(2 * time.Hour)}
+	expired := &Key{Kid: "expired", Status: StatusActive, NotBefore: at(30 * time.Minute), NotAfter: at(45 * time.Minute)}
+	next := &Key{Kid: "next", Status: StatusNext, NotBefore: at(3 * time.Hour)}
+	kr := &Keyring{Keys: []*Key{older, newer, future, expired, next}}
+
+	cases := []struct {
+		name string
+		now  time.Time
+		want *Key
+	}{
+		{"before any key", epoch.Add(-time.Second), nil},
+		{"only older", at(10 * time.Minute), older},
+		{"expired skipped", at(50 * time.Minute), older},
+		{"latest not before", at(90 * time.Minute), newer},
+		{"future becomes active", at(2 * time.Hour), future},
+		{"next never active", at(4 * time.Hour), future},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := kr.Active(c.now); got != c.want {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSigning(t *testing.T) {
+	active := &Key{Kid: "active", Status: StatusActive, NotBefore: epoch}
+	next := &Key{Kid: "next", Status: StatusNext, NotBefore: epoch}
+	retired := &Key{Kid: "retired", Status: StatusRetired, NotBefore: epoch, NotAfter: at(time.Hour)}
+	kr := &Keyring{Keys: []*Key{active, next, retired}}
+
+	cases := []struct {
+		name    string
+		kr      *Keyring
+		kid     string
+		now     time.Time
+		want    *Key
+		wantErr error
+	}{
+		{"default active", kr, "", at(time.Minute), active, nil},
+		{"pinned next", kr, "next", at(time.Minute), next, nil},
+		{"retired in grace", kr, "retired", at(time.Hour), retired, nil},
+		{"retired expired", kr, "retired", at(time.Hour + time.Second), nil, ErrKeyExpired},
+		{"unknown kid", kr, "missing", at(time.Minute), nil, ErrKeyNotFound},
+		{"no active key", &Keyring{Keys: []*Key{next}}, "", at(time.Minute), nil, ErrNoActiveKey},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.kr.Signing(c.kid, c.now)
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("got err %v, want %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPublished(t *testing.T) {
+	kr := &Keyring{Keys: []*Key{
+		{Kid: "active", PrivKey: "secret", Status: StatusActive, NotBefore: epoch},
+		{Kid: "next", PrivKey: "secret", Status: StatusNext, NotBefore: epoch},
+		{Kid: "retired", PrivKey: "secret", Status: StatusRetired, NotBefore: epoch, NotAfter: at(time.Hour)},
+	}}
+
+	cases := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{"retired in grace", at(time.Minute), []string{"active", "next", "retired"}},
+		{"retired expired", at(2 * time.Hour), []string{"active", "next"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			published := kr.Published(c.now)
+			if len(published) != len(c.want) {
+				t.Fatalf("got %d keys, want %d", len(published), len(c.want))
+			}
+			for i, key := range published {
+				if key.Kid != c.want[i] {
+					t.Fatalf("key %d: got %s, want %s", i, key.Kid, c.want[i])
+				}
+				if key.PrivKey != "" {
+					t.Fatalf("published key %s leaks the private key", key.Kid)
+				}
+			}
+		})
+	}
+	if kr.Keys[0].PrivKey != "secret" {
+		t.Fatal("Published must not modify the keyring")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	kr := &Keyring{Keys: []*Key{NewKey(StatusActive, epoch)}}
+	kr.Rotate(at(time.Hour), time.Hour)
+	path := filepath.Join(t.TempDir(), "keys", "keyring.json")
+	if err := kr.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loaded.Keys) != len(kr.Keys) {
+		t.Fatalf("got %d keys, want %d", len(loaded.Keys), len(kr.Keys))
+	}
+	for i, key := range loaded.Keys {
+		want := kr.Keys[i]
+		if key.Kid != want.Kid || key.PrivKey != want.PrivKey || key.Status != want.Status ||
+			!key.NotBefore.Equal(want.NotBefore) || !key.NotAfter.Equal(want.NotAfter) {
+			t.Fatalf("key %d changed after save and load", i)
+		}
+	}
+	if KeyId(kr.Keys[0].PubKey) != kr.Keys[0].Kid {
+		t.Fatal("kid does not match the public key")
+	}
+}
@@ -1,13 +1,113 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"time"
 
 	encrypt "github.com/tangthinker/encrypt-conn-tools/pkg"
+	"github.com/tangthinker/secret-chat-server/core"
+	"github.com/tangthinker/secret-chat-server/pkg/keyring"
+)
+
+// 不带参数时仅生成一对密钥并打印
+// 指定 -keyring 时维护密钥文件:
+//
+//	-keyring path              初始化密钥文件 导入 -config 中已有的 ecdsa 密钥作为生效密钥 并生成一把预发布密钥
+//	                           配置中没有密钥时才生成新的生效密钥
+//	-keyring path -rotate      轮换 预发布密钥转为生效 原生效密钥退役 并生成新的预发布密钥
+//	-interval 720h             与 -rotate 一起使用 生效密钥未满该时长时跳过 便于定时任务调用
+//	-retire-after 168h         退役密钥的宽限期 期间仍可用于握手
+var (
+	configPath  = flag.String("config", "./config/local/config.toml", "Path to config file with the current ecdsa key")
+	keyringPath = flag.String("keyring", "", "Path to keyring file")
+	rotate      = flag.Bool("rotate", false, "Rotate keys in keyring")
+	interval    = flag.Duration("interval", 0, "Skip rotation if the active key is younger than this")
+	retireAfter = flag.Duration("retire-after", 7*24*time.Hour, "Grace period of retired keys")
 )
 
 func main() {
-	pubKey, privKey := encrypt.GenerateKeyPairECDSA()
-	fmt.Println("pubKey:", pubKey)
-	fmt.Println("privKey:", privKey)
+	flag.Parse()
+
+	if *keyringPath == "" {
+		pubKey, privKey := encrypt.GenerateKeyPairECDSA()
+		fmt.Println("pubKey:", pubKey)
+		fmt.Println("privKey:", privKey)
+		return
+	}
+
+	if err := run(time.Now()); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(now time.Time) error {
+	kr, err := keyring.Load(*keyringPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if *rotate {
+			return fmt.Errorf("keyring %s not found", *keyringPath)
+		}
+		kr = initKeyring(now)
+		if err := kr.Save(*keyringPath); err != nil {
+			return err
+		}
+		printKeys(kr, now)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !*rotate {
+		printKeys(kr, now)
+		return nil
+	}
+
+	if active := kr.Active(now); active != nil && *interval > 0 && now.Sub(active.NotBefore) < *interval {
+		fmt.Printf("active key %s is younger than %s, skip rotation\n", active.Kid, *interval)
+		return nil
+	}
+	kr.Rotate(now, *retireAfter)
+	if err := kr.Save(*keyringPath); err != nil {
+		return err
+	}
+	printKeys(kr, now)
+	return nil
+}
+
+// initKeyring 已部署的客户端固定了配置中的公钥 必须继续作为生效密钥 否则握手失败
+func initKeyring(now time.Time) *keyring.Keyring {
+	var active *keyring.Key
+	if _, err := os.Stat(*configPath); err == nil {
+		config := core.NewConfig(*configPath)
+		privKey := config.GetString("encrypt-conn.ecdsa-priv-key")
+		pubKey := config.GetString("encrypt-conn.ecdsa-pub-key")
+		if privKey != "" && pubKey != "" {
+			active = keyring.FromSingleKey(privKey, pubKey).Keys[0]
+			active.NotBefore = now
+			fmt.Printf("imported key %s from %s\n", active.Kid, *configPath)
+		}
+	}
+	if active == nil {
+		active = keyring.NewKey(keyring.StatusActive, now)
+	}
+	return &keyring.Keyring{
+		Keys: []*keyring.Key{
+			active,
+			keyring.NewKey(keyring.StatusNext, now),
+		},
+	}
+}
+
+func printKeys(kr *keyring.Keyring, now time.Time) {
+	for _, key := range kr.Published(now) {
+		notAfter := "-"
+		if !key.NotAfter.IsZero() {
+			notAfter = key.NotAfter.Format(time.RFC3339)
+		}
+		fmt.Printf("kid: %s status: %s not_before: %s not_after: %s\n", key.Kid, key.Status, key.NotBefore.Format(time.RFC3339), notAfter)
+		fmt.Println("  pubKey:", key.PubKey)
+	}
 }